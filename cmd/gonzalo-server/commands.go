@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frizinak/gonzalo/project"
+	"github.com/frizinak/gonzalo/server"
+	"github.com/frizinak/gonzalo/users"
+)
+
+type command struct {
+	usage string
+	args  int
+	run   func(ctx context.Context, g *server.Gonzalo, args []string) error
+}
+
+var commands = map[string]command{
+	"adduser": {
+		"<name> <developer|lead|admin>",
+		2,
+		addUser,
+	},
+	"config": {
+		"<provider> <vendor> <project> <commitish> [env]",
+		4,
+		showConfig,
+	},
+	"deluser": {
+		"<name>",
+		1,
+		delUser,
+	},
+	"encrypt": {
+		"<provider> <vendor> <project> [value]",
+		3,
+		encrypt,
+	},
+	"history": {
+		"<provider> <vendor> <project> <env> [amount]",
+		4,
+		listHistory,
+	},
+	"lint": {
+		"<file>",
+		1,
+		lint,
+	},
+	"plan": {
+		"<provider> <vendor> <project> <env> <commitish>",
+		5,
+		plan,
+	},
+	"rollback": {
+		"<provider> <vendor> <project> <env> [release]",
+		4,
+		rollback,
+	},
+	"users": {
+		"",
+		0,
+		listUsers,
+	},
+}
+
+func run(
+	ctx context.Context,
+	g *server.Gonzalo,
+	name string,
+	args []string,
+) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return usage()
+	}
+
+	if len(args) < cmd.args {
+		return fmt.Errorf("Usage: %s %s %s", os.Args[0], name, cmd.usage)
+	}
+
+	return cmd.run(ctx, g, args)
+}
+
+func usage() error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(commands)+1)
+	lines = append(lines, "Usage:")
+	for _, name := range names {
+		lines = append(
+			lines,
+			fmt.Sprintf("  %s %s %s", os.Args[0], name, commands[name].usage),
+		)
+	}
+
+	return errors.New(strings.Join(lines, "\n"))
+}
+
+func rollback(ctx context.Context, g *server.Gonzalo, args []string) error {
+	prj, err := g.Project(args[0], args[1], args[2])
+	if err != nil {
+		return err
+	}
+
+	var release string
+	if len(args) > 4 {
+		release = args[4]
+	}
+
+	res, err := prj.Rollback(ctx, username(), args[3], release)
+	printResult(res)
+	return err
+}
+
+// showConfig prints the envs with their includes merged in, or the given
+// env with everything it inherits.
+func showConfig(ctx context.Context, g *server.Gonzalo, args []string) error {
+	prj, err := g.Project(args[0], args[1], args[2])
+	if err != nil {
+		return err
+	}
+
+	c, err := prj.Config(ctx, args[3])
+	if err != nil {
+		return err
+	}
+
+	var env string
+	if len(args) > 4 {
+		env = args[4]
+	}
+
+	d, err := c.Dump(env)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(d))
+	return nil
+}
+
+// encrypt encrypts the given value or stdin if there is none.
+func encrypt(ctx context.Context, g *server.Gonzalo, args []string) error {
+	prj, err := g.Project(args[0], args[1], args[2])
+	if err != nil {
+		return err
+	}
+
+	var value string
+	if len(args) > 3 {
+		value = args[3]
+	} else {
+		d, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimSuffix(string(d), "\n")
+	}
+
+	secret, err := prj.Encrypt(value)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(secret)
+	return nil
+}
+
+func lint(ctx context.Context, g *server.Gonzalo, args []string) error {
+	err := project.Lint(args[0], g.Recipes())
+	if errs, ok := err.(project.ConfigErrors); ok && !errs.Fatal() {
+		fmt.Println(errs)
+		err = nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: ok\n", args[0])
+	return nil
+}
+
+func plan(ctx context.Context, g *server.Gonzalo, args []string) error {
+	prj, err := g.Project(args[0], args[1], args[2])
+	if err != nil {
+		return err
+	}
+
+	plan, err := prj.Plan(ctx, username(), args[4], args[3])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s@%s (%s)\n", plan.Env, plan.Commitish, plan.Commit)
+	for i, batch := range plan.Hosts {
+		fmt.Printf(
+			"  batch %d  %s@%s (port %d)\n",
+			i+1,
+			plan.User,
+			strings.Join(batch, ", "),
+			plan.Port,
+		)
+	}
+	fmt.Printf("  dest     %s\n", plan.Dest)
+	fmt.Printf("  root     %s\n", plan.Root)
+	fmt.Printf("  release  %s -> %s\n", plan.Previous, plan.Release)
+	for _, r := range plan.Required {
+		fmt.Printf("  required %s\n", r)
+	}
+
+	for _, ph := range plan.Phases {
+		where := "remote"
+		if ph.Local {
+			where = "local"
+		}
+
+		fmt.Printf("  %s (%s, %s)\n", ph.Phase, where, ph.Dir)
+		if ph.Skip != "" {
+			fmt.Printf("    skipped: %s\n", ph.Skip)
+			continue
+		}
+
+		for _, cmd := range ph.Commands {
+			fmt.Printf("    %s\n", cmd)
+		}
+	}
+
+	for _, r := range plan.Prune {
+		fmt.Printf("  prune    %s\n", r)
+	}
+
+	return nil
+}
+
+func listHistory(
+	ctx context.Context,
+	g *server.Gonzalo,
+	args []string,
+) error {
+	prj, err := g.Project(args[0], args[1], args[2])
+	if err != nil {
+		return err
+	}
+
+	limit := 10
+	if len(args) > 4 {
+		if limit, err = strconv.Atoi(args[4]); err != nil {
+			return err
+		}
+	}
+
+	list, err := prj.History(args[3], limit)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range list {
+		action := "deploy"
+		if d.Rollback {
+			action = "rollback"
+		}
+
+		fmt.Printf(
+			"%s %-8s %-8s %-10s %s@%s %.7s %s\n",
+			d.Start.Format(time.RFC3339),
+			action,
+			d.Outcome,
+			d.User,
+			d.Env,
+			d.Commitish,
+			d.Commit,
+			d.Release,
+		)
+	}
+
+	return nil
+}
+
+func addUser(ctx context.Context, g *server.Gonzalo, args []string) error {
+	role, err := project.ParseRole(args[1])
+	if err != nil {
+		return err
+	}
+
+	return g.AddUser(username(), &users.User{Name: args[0], Role: role.String()})
+}
+
+func delUser(ctx context.Context, g *server.Gonzalo, args []string) error {
+	return g.DeleteUser(username(), args[0])
+}
+
+func listUsers(ctx context.Context, g *server.Gonzalo, args []string) error {
+	list, err := g.Users().List()
+	if err != nil {
+		return err
+	}
+
+	for _, u := range list {
+		fmt.Printf("%-20s %s\n", u.Name, u.Role)
+	}
+
+	return nil
+}
+
+func username() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return "unknown"
+}
+
+func printResult(res *project.Result) {
+	fmt.Printf("%s %s@%s (%s)\n", res.ID, res.Env, res.Commitish, res.Commit)
+	printPhases("  ", res.Phases)
+	for _, h := range res.Hosts {
+		fmt.Printf("  %s\n", h.Host)
+		if h.Previous != "" || res.Release != "" {
+			fmt.Printf("    release %s -> %s\n", h.Previous, res.Release)
+		}
+
+		printPhases("    ", h.Phases)
+		for _, r := range h.Pruned {
+			fmt.Printf("    pruned %s\n", r)
+		}
+
+		if h.RolledBack {
+			fmt.Printf("    rolled back to %s\n", h.Previous)
+		}
+
+		if h.Err != nil {
+			fmt.Printf("    failed: %s\n", h.Err)
+		}
+	}
+
+	if failed := res.Failed(); len(failed) != 0 && res.Err == nil {
+		fmt.Printf("  %d of %d hosts failed\n", len(failed), len(res.Hosts))
+	}
+}
+
+func printPhases(indent string, phases []*project.PhaseResult) {
+	for _, p := range phases {
+		status := "ok"
+		if p.Err != nil {
+			status = p.Err.Error()
+		}
+
+		fmt.Printf(
+			"%s%-20s %8s %s\n",
+			indent,
+			p.Phase,
+			p.End.Sub(p.Start),
+			status,
+		)
+	}
+}
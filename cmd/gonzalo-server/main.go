@@ -1,24 +1,54 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 
 	"github.com/frizinak/gonzalo/git"
+	"github.com/frizinak/gonzalo/history"
+	"github.com/frizinak/gonzalo/secrets"
 	"github.com/frizinak/gonzalo/server"
 	"github.com/frizinak/gonzalo/ssh/sshconn"
 	"github.com/frizinak/gonzalo/stores"
+	"github.com/frizinak/gonzalo/users"
 )
 
 func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	gonzalo := setup()
+	if len(os.Args) > 1 {
+		err := run(ctx, gonzalo, os.Args[1], os.Args[2:])
+		cancel()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	demo(ctx, gonzalo)
+}
+
+func setup() *server.Gonzalo {
 	gitkey := sshconn.MustPKey(sshconn.ParsePrivateKeyFile("resources/git.key"))
 	sshkey := sshconn.MustPKey(sshconn.ParsePrivateKeyFile("resources/key"))
 
 	storage := "storage"
-	storages := [2]string{
+	storages := [4]string{
 		filepath.Join(storage, "ssh", "known_hosts"),
 		filepath.Join(storage, "ssh", "private"),
+		filepath.Join(storage, "backups"),
+		filepath.Join(storage, "history"),
 	}
 
 	for _, p := range storages {
@@ -35,6 +65,21 @@ func main() {
 		panic(err)
 	}
 
+	backupStore, err := stores.NewFSBackupStorage(storages[2], 0600, true)
+	if err != nil {
+		panic(err)
+	}
+
+	historyStore, err := history.NewFSStore(storages[3])
+	if err != nil {
+		panic(err)
+	}
+
+	secretKey, err := secrets.LoadKey(filepath.Join(storage, "secret.key"))
+	if err != nil {
+		panic(err)
+	}
+
 	gonzalo, err := server.New(
 		sshkey,
 		map[string]git.Auth{
@@ -43,22 +88,36 @@ func main() {
 		},
 		hostKeyStore,
 		privateKeyStore,
+		backupStore,
+		historyStore,
+		secretKey,
+		users.NewFSStore(filepath.Join(storage, "users.json")),
 		filepath.Join(storage, "git"),
+		filepath.Join(storage, "work"),
+		"recipes",
 	)
 	if err != nil {
 		panic(err)
 	}
 
+	if err := gonzalo.LoadRoles(filepath.Join(storage, "roles.json")); err != nil {
+		panic(err)
+	}
+
+	return gonzalo
+}
+
+func demo(ctx context.Context, gonzalo *server.Gonzalo) {
 	pubrepo, err := gonzalo.Repo("github.com", "frizinak", "ym")
 	if err != nil {
 		panic(err)
 	}
 
-	if err := pubrepo.Open(); err != nil {
+	if err := pubrepo.Open(ctx); err != nil {
 		panic(err)
 	}
 
-	if err := pubrepo.Update(); err != nil {
+	if err := pubrepo.Update(ctx); err != nil {
 		panic(err)
 	}
 
@@ -67,11 +126,17 @@ func main() {
 		panic(err)
 	}
 
-	conf, err := prj.ConfigEnv("9.0.0", "dev-backend")
+	conf, err := prj.Config(ctx, "9.0.0")
+	if err != nil {
+		panic(err)
+	}
+
+	// Dump keeps secrets encrypted, unlike the env returned by ConfigEnv.
+	dump, err := conf.Dump("dev-backend")
 	if err != nil {
 		panic(err)
 	}
-	fmt.Printf("%+v\n", conf)
+	fmt.Printf("%s\n", dump)
 
 	// privaterepo, err := gonzalo.Repo("wieni.githost.io", "wieni", "sbstv")
 	// if err != nil {
@@ -85,7 +150,7 @@ func main() {
 	// 	log.Println("Failed to update private repo")
 	// }
 
-	c, err := gonzalo.SSHClient("dako.friz.pro", "22", "asdf")
+	c, err := gonzalo.SSHClient(ctx, "dako.friz.pro", "22", "asdf")
 	if err != nil {
 		panic(err)
 	}
@@ -97,7 +162,7 @@ func main() {
 	}
 
 	for _, cmd := range cmds {
-		stdout, stderr, err := c.Output(cmd, nil)
+		stdout, stderr, err := c.Output(ctx, cmd, nil)
 		if err != nil {
 			fmt.Println(err)
 		}
@@ -0,0 +1,125 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	DeployStarted  Type = "deploy-started"
+	DeployFinished Type = "deploy-finished"
+	PhaseStarted   Type = "phase-started"
+	PhaseFinished  Type = "phase-finished"
+	Output         Type = "output"
+)
+
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+)
+
+// Event is a single thing that happened during a deploy.
+type Event struct {
+	Time  time.Time `json:"time"`
+	Type  Type      `json:"type"`
+	Phase string    `json:"phase,omitempty"`
+	// Host the output originates from, "local" for local commands.
+	Host string `json:"host,omitempty"`
+	// Stdout or Stderr.
+	Stream string `json:"stream,omitempty"`
+	Line   string `json:"line,omitempty"`
+	Err    string `json:"error,omitempty"`
+}
+
+// Stream keeps all events of a deploy so subscribers can replay it from
+// the start, no matter when they subscribe.
+type Stream struct {
+	ID string
+
+	events []Event
+	closed bool
+	m      sync.Mutex
+	c      *sync.Cond
+}
+
+func NewStream(id string) *Stream {
+	s := &Stream{ID: id}
+	s.c = sync.NewCond(&s.m)
+	return s
+}
+
+// Publish adds an event to the stream. It never blocks on subscribers.
+func (s *Stream) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	s.m.Lock()
+	if !s.closed {
+		s.events = append(s.events, e)
+	}
+	s.m.Unlock()
+	s.c.Broadcast()
+}
+
+// Close marks the end of the stream, subscriber channels are closed once
+// they received all events.
+func (s *Stream) Close() {
+	s.m.Lock()
+	s.closed = true
+	s.m.Unlock()
+	s.c.Broadcast()
+}
+
+// Subscribe returns a channel that receives all events from the start of
+// the stream. Call the returned func to stop receiving events.
+func (s *Stream) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event)
+	done := make(chan struct{})
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+			s.m.Lock()
+			s.m.Unlock()
+			s.c.Broadcast()
+		})
+	}
+
+	go func() {
+		defer close(ch)
+		for i := 0; ; i++ {
+			s.m.Lock()
+			for i >= len(s.events) && !s.closed && !isDone(done) {
+				s.c.Wait()
+			}
+
+			if i >= len(s.events) || isDone(done) {
+				s.m.Unlock()
+				return
+			}
+
+			e := s.events[i]
+			s.m.Unlock()
+
+			select {
+			case ch <- e:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return ch, cancel
+}
+
+func isDone(done chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,68 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func collect(t *testing.T, ch <-chan Event) []string {
+	var lines []string
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return lines
+			}
+			lines = append(lines, e.Line)
+		case <-time.After(time.Second):
+			t.Fatal("expected the channel to be closed")
+		}
+	}
+}
+
+func TestReplayAfterClose(t *testing.T) {
+	s := NewStream("id")
+	s.Publish(Event{Type: Output, Line: "a"})
+	s.Publish(Event{Type: Output, Line: "b"})
+	s.Close()
+	s.Publish(Event{Type: Output, Line: "dropped"})
+
+	for i := 0; i < 2; i++ {
+		ch, cancel := s.Subscribe()
+		lines := collect(t, ch)
+		cancel()
+		if len(lines) != 2 || lines[0] != "a" || lines[1] != "b" {
+			t.Errorf("expected [a b], got %v", lines)
+		}
+	}
+}
+
+func TestSubscribeLive(t *testing.T) {
+	s := NewStream("id")
+	s.Publish(Event{Type: Output, Line: "a"})
+	ch, cancel := s.Subscribe()
+	defer cancel()
+
+	if e := <-ch; e.Line != "a" || e.Time.IsZero() {
+		t.Errorf("expected a with a time, got %+v", e)
+	}
+
+	go func() {
+		s.Publish(Event{Type: Output, Line: "b"})
+		s.Close()
+	}()
+
+	if lines := collect(t, ch); len(lines) != 1 || lines[0] != "b" {
+		t.Errorf("expected [b], got %v", lines)
+	}
+}
+
+func TestSubscribeCancel(t *testing.T) {
+	s := NewStream("id")
+	ch, cancel := s.Subscribe()
+	cancel()
+	cancel()
+
+	// The stream is still open, the channel is closed by cancel alone.
+	collect(t, ch)
+}
@@ -0,0 +1,35 @@
+package events
+
+import "sync"
+
+// Hub keeps track of the streams of running deploys.
+type Hub struct {
+	streams map[string]*Stream
+	m       sync.RWMutex
+}
+
+func NewHub() *Hub {
+	return &Hub{streams: map[string]*Stream{}}
+}
+
+// Register makes s available under key until the returned func is called.
+func (h *Hub) Register(key string, s *Stream) func() {
+	h.m.Lock()
+	h.streams[key] = s
+	h.m.Unlock()
+
+	return func() {
+		h.m.Lock()
+		if h.streams[key] == s {
+			delete(h.streams, key)
+		}
+		h.m.Unlock()
+	}
+}
+
+// Get returns the stream registered under key or nil.
+func (h *Hub) Get(key string) *Stream {
+	h.m.RLock()
+	defer h.m.RUnlock()
+	return h.streams[key]
+}
@@ -0,0 +1,70 @@
+package events
+
+import (
+	"bytes"
+	"sync"
+)
+
+// LineWriter publishes everything written to it as Output events, one per
+// line. Call Flush to publish a trailing partial line.
+type LineWriter struct {
+	s      *Stream
+	phase  string
+	host   string
+	stream string
+	filter func(string) string
+	buf    bytes.Buffer
+	m      sync.Mutex
+}
+
+func NewLineWriter(s *Stream, phase, host, stream string) *LineWriter {
+	return &LineWriter{s: s, phase: phase, host: host, stream: stream}
+}
+
+// SetFilter sets a func every line is passed through before it is
+// published.
+func (w *LineWriter) SetFilter(fn func(string) string) {
+	w.m.Lock()
+	w.filter = fn
+	w.m.Unlock()
+}
+
+func (w *LineWriter) Write(b []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.buf.Write(b)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+
+		line := string(w.buf.Next(i + 1))
+		w.publish(line[:len(line)-1])
+	}
+
+	return len(b), nil
+}
+
+func (w *LineWriter) Flush() {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.buf.Len() != 0 {
+		w.publish(w.buf.String())
+		w.buf.Reset()
+	}
+}
+
+func (w *LineWriter) publish(line string) {
+	if w.filter != nil {
+		line = w.filter(line)
+	}
+
+	w.s.Publish(Event{
+		Type:   Output,
+		Phase:  w.phase,
+		Host:   w.host,
+		Stream: w.stream,
+		Line:   line,
+	})
+}
@@ -0,0 +1,112 @@
+package git
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// Export writes the tree of the given commitish to dir without touching
+// the worktree. dir must not exist yet.
+func (r *Repo) Export(ctx context.Context, commitish, dir string) error {
+	hash, err := r.resolve(ctx, commitish)
+	if err != nil {
+		return err
+	}
+
+	commit, err := r.repo.CommitObject(hash)
+	if err != nil {
+		return err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+
+	return tree.Files().ForEach(func(f *object.File) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return export(f, filepath.Join(dir, filepath.FromSlash(f.Name)))
+	})
+}
+
+func export(f *object.File, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if f.Mode == filemode.Symlink {
+		target, err := f.Contents()
+		if err != nil {
+			return err
+		}
+
+		return os.Symlink(target, path)
+	}
+
+	mode, err := f.Mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+
+	r, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// Exists reports whether path exists in the tree of the given commitish.
+func (r *Repo) Exists(ctx context.Context, commitish, path string) (
+	bool,
+	error,
+) {
+	hash, err := r.resolve(ctx, commitish)
+	if err != nil {
+		return false, err
+	}
+
+	commit, err := r.repo.CommitObject(hash)
+	if err != nil {
+		return false, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tree.FindEntry(strings.Trim(path, "/"))
+	switch err {
+	case nil:
+		return true, nil
+	case object.ErrEntryNotFound, object.ErrDirectoryNotFound:
+		return false, nil
+	}
+
+	return false, err
+}
@@ -1,6 +1,7 @@
 package git
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"os"
@@ -22,6 +23,8 @@ type Repo struct {
 	project  string
 	path     string
 	repo     *git.Repository
+	// Holds a value while the repo is locked.
+	mu chan struct{}
 }
 
 func New(
@@ -43,6 +46,7 @@ func New(
 		vendor:   vendor,
 		project:  project,
 		path:     filepath.Join(dir, path),
+		mu:       make(chan struct{}, 1),
 	}, nil
 }
 
@@ -50,15 +54,37 @@ func (r *Repo) Path() string {
 	return r.path
 }
 
+// Lock locks the repo for exclusive use. The worktree is shared by all
+// users of the repo so callers that update, reset or read from it should
+// hold the lock. ctx.Err() is returned if ctx is done before the lock was
+// acquired.
+func (r *Repo) Lock(ctx context.Context) error {
+	select {
+	case r.mu <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Repo) Unlock() {
+	<-r.mu
+}
+
+// Name returns provider/vendor/project.
+func (r *Repo) Name() string {
+	return strings.Join([]string{r.provider, r.vendor, r.project}, "/")
+}
+
 // Open opens the repo if it exists, clones it otherwise.
-func (r *Repo) Open() error {
+func (r *Repo) Open(ctx context.Context) error {
 	if r.repo != nil {
 		return nil
 	}
 
 	repo, err := git.PlainOpen(r.path)
 	if err != nil {
-		return r.Update()
+		return r.Update(ctx)
 	}
 
 	r.repo = repo
@@ -66,23 +92,28 @@ func (r *Repo) Open() error {
 }
 
 // Update opens the repo if it exists, clones it if not and runs git fetch.
-func (r *Repo) Update() (err error) {
+// A cancelled fetch leaves an existing repo as is, a cancelled clone is
+// removed.
+func (r *Repo) Update(ctx context.Context) (err error) {
 	defer func() {
-		if err != nil {
+		if err != nil && (r.repo == nil || ctx.Err() == nil) {
+			r.repo = nil
 			r.Delete()
 		}
 	}()
 
 	clone := func() {
+		r.repo = nil
 		if err = r.Delete(); err != nil {
 			return
 		}
 
-		err = r.clone()
+		err = r.clone(ctx)
 	}
 
 	fetch := func() {
-		err = r.repo.Fetch(
+		err = r.repo.FetchContext(
+			ctx,
 			&git.FetchOptions{
 				RemoteName: remote,
 				Auth:       r.getAuth(),
@@ -93,7 +124,7 @@ func (r *Repo) Update() (err error) {
 			err = nil
 		}
 
-		if err != nil {
+		if err != nil && ctx.Err() == nil {
 			clone()
 		}
 	}
@@ -111,35 +142,57 @@ func (r *Repo) Update() (err error) {
 	}
 
 	r.repo = repo
-	if fetch(); err != nil {
-		r.repo = nil
-	}
-
+	fetch()
 	return
 }
 
 // Reset resets the repo (hard) to the given commitish
-func (r *Repo) Reset(commitish string) error {
-	if err := r.Open(); err != nil {
+func (r *Repo) Reset(ctx context.Context, commitish string) error {
+	hash, err := r.resolve(ctx, commitish)
+	if err != nil {
 		return err
 	}
 
+	return reset(r.repo, hash)
+}
+
+// Resolve returns the full commit hash of the given commitish.
+func (r *Repo) Resolve(ctx context.Context, commitish string) (
+	string,
+	error,
+) {
+	hash, err := r.resolve(ctx, commitish)
+	if err != nil {
+		return "", err
+	}
+
+	return hash.String(), nil
+}
+
+func (r *Repo) resolve(ctx context.Context, commitish string) (
+	plumbing.Hash,
+	error,
+) {
+	if err := r.Open(ctx); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
 	head, err := r.repo.Head()
 	if err != nil {
-		return err
+		return plumbing.ZeroHash, err
 	}
-	current := head.Hash()
-	if current.String() == commitish {
-		return reset(r.repo, current)
+
+	if current := head.Hash(); current.String() == commitish {
+		return current, nil
 	}
 
 	commits, err := lookup(r.repo, commitish)
 	if err != nil {
-		return err
+		return plumbing.ZeroHash, err
 	}
 
 	if len(commits) == 0 {
-		return fmt.Errorf("No such commitish: %s", commitish)
+		return plumbing.ZeroHash, fmt.Errorf("No such commitish: %s", commitish)
 	}
 
 	if len(commits) > 1 {
@@ -148,18 +201,22 @@ func (r *Repo) Reset(commitish string) error {
 			refs[i] = commits[i].ref
 		}
 
-		return fmt.Errorf("Ambiguous commitish: %s", strings.Join(refs, ", "))
+		return plumbing.ZeroHash, fmt.Errorf(
+			"Ambiguous commitish: %s",
+			strings.Join(refs, ", "),
+		)
 	}
 
-	return reset(r.repo, commits[0].commit.Hash)
+	return commits[0].commit.Hash, nil
 }
 
 func (r *Repo) Delete() error {
 	return os.RemoveAll(r.path)
 }
 
-func (r *Repo) clone() error {
-	repo, err := git.PlainClone(
+func (r *Repo) clone(ctx context.Context) error {
+	repo, err := git.PlainCloneContext(
+		ctx,
 		r.path,
 		false,
 		&git.CloneOptions{
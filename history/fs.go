@@ -0,0 +1,146 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const ext = ".json"
+
+// FSStore stores each deploy as dir/<project>/<env>/<id>.json.
+type FSStore struct {
+	dir string
+	m   sync.RWMutex
+}
+
+func NewFSStore(dir string) (*FSStore, error) {
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return &FSStore{dir: dir}, nil
+}
+
+func (fs *FSStore) Save(d *Deploy) error {
+	path, err := fs.path(d.Project, d.Env, d.ID)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (fs *FSStore) Get(project, env, id string) (*Deploy, error) {
+	path, err := fs.path(project, env, id)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.m.RLock()
+	defer fs.m.RUnlock()
+	return read(path)
+}
+
+func (fs *FSStore) List(project, env string, limit int) ([]*Deploy, error) {
+	dir, err := fs.path(project, env, "")
+	if err != nil {
+		return nil, err
+	}
+
+	fs.m.RLock()
+	defer fs.m.RUnlock()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Deploy{}, nil
+		}
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ext) {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	if limit > 0 && len(names) > limit {
+		names = names[:limit]
+	}
+
+	list := make([]*Deploy, 0, len(names))
+	for _, n := range names {
+		d, err := read(filepath.Join(dir, n))
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, d)
+	}
+
+	return list, nil
+}
+
+func (fs *FSStore) path(project, env, id string) (string, error) {
+	for _, p := range append(strings.Split(project, "/"), env) {
+		if !validName(p) {
+			return "", fmt.Errorf("Invalid history path component: '%s'", p)
+		}
+	}
+
+	dir := filepath.Join(fs.dir, filepath.FromSlash(project), env)
+	if id == "" {
+		return dir, nil
+	}
+
+	if !validName(id) {
+		return "", fmt.Errorf("Invalid deploy id: '%s'", id)
+	}
+
+	return filepath.Join(dir, id+ext), nil
+}
+
+func read(path string) (*Deploy, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Deploy{}
+	return d, json.Unmarshal(raw, d)
+}
+
+func validName(n string) bool {
+	return n != "" &&
+		n != "." &&
+		n != ".." &&
+		!strings.ContainsAny(n, `/\`)
+}
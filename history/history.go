@@ -0,0 +1,69 @@
+package history
+
+import (
+	"time"
+)
+
+type Outcome string
+
+const (
+	OutcomeRunning Outcome = "running"
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailed  Outcome = "failed"
+	// Some hosts failed, but no more than the env allows.
+	OutcomePartial Outcome = "partial"
+	// The health check failed and current was switched back.
+	OutcomeRolledBack Outcome = "rolled-back"
+	// The user was not allowed to deploy, Err holds the reason.
+	OutcomeDenied Outcome = "denied"
+)
+
+// Phase is the recorded status of a single deploy phase.
+type Phase struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Err   string    `json:"error,omitempty"`
+}
+
+// Host is the recorded outcome of a deploy on a single host.
+type Host struct {
+	Host     string   `json:"host"`
+	Previous string   `json:"previous,omitempty"`
+	Pruned   []string `json:"pruned,omitempty"`
+	Phases   []Phase  `json:"phases"`
+	// Whether current was switched back to Previous.
+	RolledBack bool   `json:"rolled-back,omitempty"`
+	Err        string `json:"error,omitempty"`
+}
+
+// Deploy is a recorded deploy or rollback.
+type Deploy struct {
+	ID        string    `json:"id"`
+	Project   string    `json:"project"`
+	Env       string    `json:"env"`
+	Commitish string    `json:"commitish"`
+	Commit    string    `json:"commit"`
+	User      string    `json:"user"`
+	Rollback  bool      `json:"rollback,omitempty"`
+	Release   string    `json:"release,omitempty"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Phases    []Phase   `json:"phases"`
+	Hosts     []Host    `json:"hosts,omitempty"`
+	Outcome   Outcome   `json:"outcome"`
+	Err       string    `json:"error,omitempty"`
+}
+
+// Store is the audit log of deploys, meant to be read by people.
+// It is not used to decide what a deploy or rollback does on a host, see
+// the journal of the project package for that.
+type Store interface {
+	// Save creates or overwrites the deploy with the same project, env
+	// and id.
+	Save(*Deploy) error
+	Get(project, env, id string) (*Deploy, error)
+	// List returns at most limit deploys of the env, most recent first.
+	// A limit < 1 returns all of them.
+	List(project, env string, limit int) ([]*Deploy, error)
+}
@@ -0,0 +1,95 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Lock describes who holds a lock.
+type Lock struct {
+	Owner string
+	Since time.Time
+}
+
+// InUseError is returned when a lock is held by someone else.
+type InUseError struct {
+	Key string
+	Lock
+}
+
+func (e *InUseError) Error() string {
+	return fmt.Sprintf(
+		"Deploy in progress by %s since %s",
+		e.Owner,
+		e.Since.Format(time.RFC3339),
+	)
+}
+
+type entry struct {
+	Lock
+	done chan struct{}
+}
+
+// Manager hands out exclusive locks by key.
+type Manager struct {
+	locks map[string]*entry
+	m     sync.Mutex
+}
+
+func NewManager() *Manager {
+	return &Manager{locks: map[string]*entry{}}
+}
+
+// Acquire locks key for owner. If the lock is held and wait is true it
+// blocks until the lock is released or ctx is done, an *InUseError is
+// returned otherwise. The returned func releases the lock.
+func (m *Manager) Acquire(
+	ctx context.Context,
+	key, owner string,
+	wait bool,
+) (func(), error) {
+	for {
+		m.m.Lock()
+		e, ok := m.locks[key]
+		if !ok {
+			e = &entry{Lock{owner, time.Now()}, make(chan struct{})}
+			m.locks[key] = e
+			m.m.Unlock()
+			return func() { m.release(key, e) }, nil
+		}
+		m.m.Unlock()
+
+		if !wait {
+			return nil, &InUseError{key, e.Lock}
+		}
+
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Get returns the current holder of the lock on key, if any.
+func (m *Manager) Get(key string) (Lock, bool) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	e, ok := m.locks[key]
+	if !ok {
+		return Lock{}, false
+	}
+
+	return e.Lock, true
+}
+
+func (m *Manager) release(key string, e *entry) {
+	m.m.Lock()
+	if m.locks[key] == e {
+		delete(m.locks, key)
+		close(e.done)
+	}
+	m.m.Unlock()
+}
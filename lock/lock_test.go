@@ -0,0 +1,109 @@
+package lock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	m := NewManager()
+	ctx := context.Background()
+	unlock, err := m.Acquire(ctx, "k", "a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l, ok := m.Get("k"); !ok || l.Owner != "a" {
+		t.Errorf("expected the lock to be held by a, got %+v", l)
+	}
+
+	_, err = m.Acquire(ctx, "k", "b", false)
+	if e, ok := err.(*InUseError); !ok || e.Owner != "a" || e.Key != "k" {
+		t.Errorf("expected an InUseError for a, got %v", err)
+	}
+
+	if _, err := m.Acquire(ctx, "other", "b", false); err != nil {
+		t.Errorf("expected other keys to be free, got %v", err)
+	}
+
+	unlock()
+	// A second release must not release the lock of someone else.
+	unlockB, err := m.Acquire(ctx, "k", "b", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if l, ok := m.Get("k"); !ok || l.Owner != "b" {
+		t.Errorf("expected the lock to be held by b, got %+v", l)
+	}
+	unlockB()
+
+	if _, ok := m.Get("k"); ok {
+		t.Error("expected the lock to be released")
+	}
+}
+
+func TestAcquireWait(t *testing.T) {
+	m := NewManager()
+	ctx := context.Background()
+	unlock, err := m.Acquire(ctx, "k", "a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		unlock, err := m.Acquire(ctx, "k", "b", true)
+		if err == nil {
+			unlock()
+		}
+		acquired <- err
+	}()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("expected to wait for the lock, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the lock to be acquired once released")
+	}
+}
+
+func TestAcquireCancel(t *testing.T) {
+	m := NewManager()
+	unlock, err := m.Acquire(context.Background(), "k", "a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := m.Acquire(ctx, "k", "b", true)
+		acquired <- err
+	}()
+
+	cancel()
+	select {
+	case err := <-acquired:
+		if err != context.Canceled {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Acquire to return once ctx is done")
+	}
+
+	if l, _ := m.Get("k"); l.Owner != "a" {
+		t.Errorf("expected the lock to still be held by a, got %+v", l)
+	}
+}
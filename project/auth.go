@@ -0,0 +1,69 @@
+package project
+
+import (
+	"fmt"
+
+	"github.com/frizinak/gonzalo/users"
+)
+
+// ForbiddenError is returned when a user may not deploy an env.
+type ForbiddenError struct {
+	User     string
+	Env      string
+	Role     Role
+	Required Role
+	Reason   string
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("User %s may not deploy %s: %s", e.User, e.Env, e.Reason)
+}
+
+// authorize checks whether user has the role required to deploy env: the
+// role set on the gonzalo server for the env or the Role of env, whichever
+// is higher. The Role of env comes from the commit being deployed, which
+// the user chooses, so it can only raise the required role.
+// Authorization is disabled if the project has no user store.
+func (p *Project) authorize(user, name string, env Env) error {
+	if p.users == nil {
+		return nil
+	}
+
+	required := p.roles[name]
+	if env.Role > required {
+		required = env.Role
+	}
+
+	deny := func(role Role, reason string) error {
+		return &ForbiddenError{
+			User:     user,
+			Env:      name,
+			Role:     role,
+			Required: required,
+			Reason:   reason,
+		}
+	}
+
+	u, err := p.users.Get(user)
+	if err == users.ErrNotFound {
+		return deny(RoleNone, "unknown user")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	role, err := ParseRole(u.Role)
+	if err != nil {
+		return deny(RoleNone, err.Error())
+	}
+
+	if role < required {
+		return deny(
+			role,
+			fmt.Sprintf("role %s is below the required %s", role, required),
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/frizinak/gonzalo/users"
+)
+
+func TestAuthorize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gonzalo-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := users.NewFSStore(filepath.Join(dir, "users.json"))
+	for _, u := range []*users.User{
+		{Name: "dev", Role: "developer"},
+		{Name: "lead", Role: "lead"},
+	} {
+		if err := store.Save(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &Project{users: store, roles: map[string]Role{"prod": RoleLead}}
+	tests := []struct {
+		user    string
+		env     string
+		role    Role
+		allowed bool
+	}{
+		{"dev", "staging", RoleNone, true},
+		{"dev", "staging", RoleDeveloper, true},
+		{"dev", "staging", RoleLead, false},
+		{"nobody", "staging", RoleNone, false},
+		{"lead", "prod", RoleNone, true},
+		// The deployed commit can not lower the role of the server.
+		{"dev", "prod", RoleDeveloper, false},
+		{"lead", "prod", RoleAdmin, false},
+	}
+
+	for _, test := range tests {
+		err := p.authorize(test.user, test.env, Env{Role: test.role})
+		if _, ok := err.(*ForbiddenError); err != nil && !ok {
+			t.Fatal(err)
+		}
+
+		if allowed := err == nil; allowed != test.allowed {
+			t.Errorf(
+				"%s %s with role %s: expected allowed %t, got %v",
+				test.user,
+				test.env,
+				test.role,
+				test.allowed,
+				err,
+			)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package project
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+
+	"github.com/frizinak/gonzalo/stores"
+)
+
+// backup runs every Env.Backup command on the primary host and streams
+// its stdout to the backup storage. There is nothing to back up before the
+// first deploy.
+func backup(ctx context.Context, d *deploy, pr *PhaseResult) error {
+	if !d.primary || d.host.Previous == "" {
+		return nil
+	}
+
+	for _, name := range backupNames(d.env) {
+		cmd := d.env.Backup[name]
+		var a *stores.Artifact
+		err := try(ctx, cmd, func(ctx context.Context) error {
+			var err error
+			a, err = d.backup(ctx, name, cmd)
+			return err
+		})
+		if a != nil {
+			d.result.Backups = append(d.result.Backups, a)
+		}
+
+		cmd = d.maskCommand(cmd)
+		pr.Commands = append(pr.Commands, &CommandResult{Command: cmd, Err: err})
+		if err != nil {
+			return &PhaseError{Phase: pr.Phase, Command: cmd, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func backupNames(env Env) []string {
+	names := make([]string, 0, len(env.Backup))
+	for name := range env.Backup {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (d *deploy) backup(
+	ctx context.Context,
+	name string,
+	cmd Command,
+) (*stores.Artifact, error) {
+	var stderr bytes.Buffer
+	_, errw := d.output(&PhaseResult{Phase: PhaseBackup}, d.host.Host)
+	defer errw.Flush()
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	var a *stores.Artifact
+	var serr error
+	go func() {
+		a, serr = d.project.backups.Store(
+			d.project.repo.Name(),
+			d.result.Env,
+			d.result.ID,
+			name,
+			r,
+		)
+		r.CloseWithError(serr)
+		close(done)
+	}()
+
+	err := d.conn.Stream(
+		ctx,
+		d.shell(remoteCwd(current(d), cmd), cmd),
+		nil,
+		w,
+		io.MultiWriter(&stderr, errw),
+	)
+	w.CloseWithError(err)
+	<-done
+
+	if err != nil {
+		return nil, cmdError(err, []byte(d.mask(stderr.String())))
+	}
+
+	return a, serr
+}
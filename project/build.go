@@ -0,0 +1,259 @@
+package project
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// localHost tags output of commands running on the gonzalo machine.
+const localHost = "local"
+
+// buildEnvVars are copied from the gonzalo process into the environment of
+// local build commands, everything else is dropped.
+var buildEnvVars = []string{"PATH", "HOME", "USER", "LANG", "LC_ALL"}
+
+// workspace returns the directory a deploy's build is exported to.
+func (p *Project) workspace(id string) string {
+	return filepath.Join(p.workdir, filepath.FromSlash(p.repo.Name()), id)
+}
+
+// Amount of builds kept per BuildKey, the least recently used ones are
+// removed when a new build is cached.
+const keepBuilds = 3
+
+// buildCache returns the directory a build for the given commit and
+// BuildKey is shared in, or an empty string if the env has no BuildKey.
+// Builds of configs with different recipes are not shared.
+func (p *Project) buildCache(commit string, env Env) (string, error) {
+	if env.BuildKey == "" {
+		return "", nil
+	}
+
+	if env.BuildKey == "." ||
+		env.BuildKey == ".." ||
+		strings.ContainsAny(env.BuildKey, `/\`) {
+		return "", fmt.Errorf("Invalid buildkey '%s'", env.BuildKey)
+	}
+
+	name := commit
+	if env.recipes != "" {
+		name += "-" + env.recipes
+	}
+
+	return filepath.Join(
+		p.workdir,
+		filepath.FromSlash(p.repo.Name()),
+		"builds",
+		env.BuildKey,
+		name,
+	), nil
+}
+
+// useBuild reports whether the given cached build exists and marks it as
+// used until releaseBuild is called. If build is not empty it is moved to
+// the cache first, unless the cache already exists.
+func (p *Project) useBuild(cache, build string) bool {
+	p.work.mu.Lock()
+	defer p.work.mu.Unlock()
+
+	if build != "" {
+		if err := os.Rename(build, cache); err != nil {
+			return false
+		}
+	} else if _, err := os.Stat(cache); err != nil {
+		return false
+	}
+
+	now := time.Now()
+	os.Chtimes(cache, now, now)
+	p.work.builds[cache]++
+
+	return true
+}
+
+func (p *Project) releaseBuild(cache string) {
+	p.work.mu.Lock()
+	defer p.work.mu.Unlock()
+
+	if p.work.builds[cache]--; p.work.builds[cache] <= 0 {
+		delete(p.work.builds, cache)
+	}
+}
+
+// evictBuilds removes the builds in dir, except for the keepBuilds most
+// recently used ones and those that are in use.
+func (p *Project) evictBuilds(dir string) error {
+	p.work.mu.Lock()
+	defer p.work.mu.Unlock()
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].ModTime().After(fis[j].ModTime())
+	})
+
+	for i, fi := range fis {
+		build := filepath.Join(dir, fi.Name())
+		if i < keepBuilds || p.work.builds[build] > 0 {
+			continue
+		}
+
+		if err := os.RemoveAll(build); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// root returns the absolute path of env.Root inside dir.
+func root(dir string, env Env) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(env.Root))
+	if filepath.IsAbs(rel) ||
+		rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Root %s is not inside the repo", env.Root)
+	}
+
+	return filepath.Join(dir, rel), nil
+}
+
+// build exports the commit and runs the Build commands. Builds of envs
+// sharing a BuildKey are cached and reused for the same commit.
+func build(ctx context.Context, d *deploy, pr *PhaseResult) error {
+	cache, err := d.project.buildCache(d.result.Commit, d.env)
+	if err != nil {
+		return err
+	}
+
+	if cache != "" && d.project.useBuild(cache, "") {
+		d.build, d.cache = cache, cache
+		d.result.BuildReused = true
+		return d.tmpDir()
+	}
+
+	if err := d.runBuild(ctx, pr); err != nil {
+		return err
+	}
+
+	d.build = d.workspace
+	if cache == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cache), 0755); err != nil {
+		return err
+	}
+
+	// Another deploy might have finished the same build in the meantime,
+	// in which case we just use ours.
+	if !d.project.useBuild(cache, d.workspace) {
+		return nil
+	}
+
+	d.build, d.cache = cache, cache
+	// Old builds taking up space do not fail the deploy.
+	d.project.evictBuilds(filepath.Dir(cache))
+	return nil
+}
+
+func (d *deploy) runBuild(ctx context.Context, pr *PhaseResult) error {
+	if err := d.exportWorkspace(ctx); err != nil {
+		return err
+	}
+
+	dir, err := root(d.workspace, d.env)
+	if err != nil {
+		return err
+	}
+
+	for _, cmd := range d.env.Build {
+		if err := d.command(ctx, pr, dir, cmd, OnLocal); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exportWorkspace exports the commit of the deploy to its workspace.
+func (d *deploy) exportWorkspace(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(d.workspace), 0755); err != nil {
+		return err
+	}
+
+	if err := d.project.export(ctx, d.result.Commit, d.workspace); err != nil {
+		return err
+	}
+
+	return d.tmpDir()
+}
+
+// tmpDir creates the TMPDIR of local commands.
+func (d *deploy) tmpDir() error {
+	return os.MkdirAll(d.workspace+".tmp", 0700)
+}
+
+// localEnv returns the environment of a local command.
+func (d *deploy) localEnv(vars map[string]string) []string {
+	env := []string{"TMPDIR=" + d.workspace + ".tmp"}
+	for _, k := range buildEnvVars {
+		if v, ok := os.LookupEnv(k); ok {
+			env = append(env, k+"="+v)
+		}
+	}
+
+	for _, k := range sortedKeys(vars) {
+		env = append(env, k+"="+vars[k])
+	}
+
+	return env
+}
+
+func (d *deploy) local(
+	ctx context.Context,
+	pr *PhaseResult,
+	dir string,
+	cmd Command,
+) error {
+	var stdout, stderr bytes.Buffer
+	outw, errw := d.output(pr, localHost)
+	vars := d.cmdVars(cmd)
+	c := exec.CommandContext(ctx, "sh", "-c", expand(cmd.Run))
+	c.Dir = dir
+	c.Env = d.localEnv(vars)
+	c.Stdout = io.MultiWriter(&stdout, outw)
+	c.Stderr = io.MultiWriter(&stderr, errw)
+
+	err := c.Run()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	outw.Flush()
+	errw.Flush()
+	return d.recordCommand(pr, cmd, stdout.Bytes(), stderr.Bytes(), err)
+}
+
+func (d *deploy) cleanWorkspace() error {
+	if d.cache != "" {
+		d.project.releaseBuild(d.cache)
+	}
+
+	if err := os.RemoveAll(d.workspace + ".tmp"); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(d.workspace)
+}
@@ -0,0 +1,113 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// command runs cmd where its On says, defaulting to on, the side the phase
+// runs on, in dir. Local commands of remote phases run in the build.
+func (d *deploy) command(
+	ctx context.Context,
+	pr *PhaseResult,
+	dir string,
+	cmd Command,
+	on string,
+) error {
+	if cmd.On != "" && cmd.On != on {
+		if cmd.On != OnLocal {
+			return &PhaseError{
+				Phase:   pr.Phase,
+				Command: cmd,
+				Err:     errors.New("Only local commands can run in this phase"),
+			}
+		}
+
+		if d.build == "" {
+			return &PhaseError{
+				Phase:   pr.Phase,
+				Command: cmd,
+				Err:     errors.New("Local commands need a build"),
+			}
+		}
+
+		var err error
+		if dir, err = root(d.build, d.env); err != nil {
+			return err
+		}
+		on = OnLocal
+	}
+
+	if on == OnLocal {
+		dir = localCwd(dir, cmd)
+		return try(ctx, cmd, func(ctx context.Context) error {
+			return d.local(ctx, pr, dir, cmd)
+		})
+	}
+
+	dir = remoteCwd(dir, cmd)
+	return try(ctx, cmd, func(ctx context.Context) error {
+		return d.remote(ctx, pr, dir, cmd)
+	})
+}
+
+// localCwd returns the working directory of cmd on the gonzalo machine.
+func localCwd(dir string, cmd Command) string {
+	if filepath.IsAbs(cmd.Cwd) {
+		return cmd.Cwd
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(cmd.Cwd))
+}
+
+// remoteCwd returns the working directory of cmd on the remote.
+func remoteCwd(dir string, cmd Command) string {
+	if path.IsAbs(cmd.Cwd) {
+		return cmd.Cwd
+	}
+
+	return path.Join(dir, cmd.Cwd)
+}
+
+// try runs fn with the Timeout of cmd and runs it again up to Retries
+// times if it fails. The error is dropped if the command has
+// IgnoreFailure set.
+func try(
+	ctx context.Context,
+	cmd Command,
+	fn func(context.Context) error,
+) error {
+	var err error
+	for i := 0; i <= cmd.Retries; i++ {
+		if err = attempt(ctx, cmd, fn); err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+
+	if cmd.IgnoreFailure {
+		return nil
+	}
+
+	return err
+}
+
+func attempt(
+	ctx context.Context,
+	cmd Command,
+	fn func(context.Context) error,
+) error {
+	if cmd.Timeout <= 0 {
+		return fn(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(
+		ctx,
+		time.Duration(cmd.Timeout)*time.Second,
+	)
+	defer cancel()
+
+	return fn(ctx)
+}
@@ -2,26 +2,159 @@ package project
 
 import (
 	"fmt"
-	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
-type Command string
+const (
+	OnLocal  = "local"
+	OnRemote = "remote"
+)
+
+// Command is a shell command. In a .deploy file it is either a string or
+// a mapping with the options below.
+type Command struct {
+	Run string `yaml:"run"`
+	// Seconds after which the command is killed, 0 never kills it.
+	Timeout int `yaml:"timeout"`
+	// Amount of times a failing command is run again.
+	Retries int `yaml:"retries"`
+	// Continue the phase if the command keeps failing.
+	IgnoreFailure bool `yaml:"ignore-failure"`
+	// Run on the gonzalo machine (local) or on the host (remote).
+	// Defaults to where the phase runs. Local commands of remote phases
+	// run once per host in the build.
+	On string `yaml:"on"`
+	// Working directory, relative to the directory of the phase.
+	Cwd string `yaml:"cwd"`
+	// Additional environment variables.
+	Env map[string]string `yaml:"env"`
+}
+
+func (c *Command) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var run string
+	if err := unmarshal(&run); err == nil {
+		*c = Command{Run: run}
+		return nil
+	}
+
+	type plain Command
+	return unmarshal((*plain)(c))
+}
+
+func (c Command) String() string {
+	return c.Run
+}
+
+// Role is the privilege level of a user, higher roles may do everything
+// lower roles may.
 type Role uint
 
+const (
+	RoleNone Role = iota
+	RoleDeveloper
+	RoleLead
+	RoleAdmin
+)
+
+var roleNames = []string{"none", "developer", "lead", "admin"}
+
+// ParseRole returns the role with the given name or number.
+func ParseRole(name string) (Role, error) {
+	for i, n := range roleNames {
+		if n == name {
+			return Role(i), nil
+		}
+	}
+
+	if n, err := strconv.ParseUint(name, 10, 0); err == nil &&
+		n < uint64(len(roleNames)) {
+		return Role(n), nil
+	}
+
+	return RoleNone, fmt.Errorf("Unknown role %s", name)
+}
+
+func (r Role) String() string {
+	if int(r) < len(roleNames) {
+		return roleNames[r]
+	}
+
+	return strconv.FormatUint(uint64(r), 10)
+}
+
+func (r *Role) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err != nil {
+		return err
+	}
+
+	role, err := ParseRole(name)
+	*r = role
+	return err
+}
+
+func (r Role) MarshalYAML() (interface{}, error) {
+	return r.String(), nil
+}
+
+// Health describes how to verify a release after PostDeploy.
+// Both the commands and the URL have to succeed.
+type Health struct {
+	// URL requested by the gonzalo server.
+	URL string `yaml:"url"`
+	// Expected response status, defaults to 200.
+	Status int `yaml:"status"`
+	// Regular expression the response body has to match.
+	Body string `yaml:"body"`
+	// Request timeout in seconds, defaults to 10.
+	Timeout int `yaml:"timeout"`
+
+	// Commands run in the current release that have to exit 0.
+	Commands []Command `yaml:"commands"`
+
+	// Amount of times a failing check is retried.
+	Retries int `yaml:"retries"`
+	// Seconds between attempts, defaults to 5.
+	Interval int `yaml:"interval"`
+}
+
 type Env struct {
 	// Share deploy artifacts by overriding the env with this value.
+	// Envs with the same BuildKey reuse each other's build of a commit.
 	BuildKey string `yaml:"buildkey"`
 
 	// Amount of deployment backups to keep.
-	Backups int `yaml:"backups"`
+	// Older releases are pruned after each deploy. All of them are kept
+	// if not set or negative, 0 keeps none.
+	Backups *int `yaml:"backups"`
 
-	// Deprecated
+	// Deprecated: use Host, User and Port.
+	// A [user@]host[:port] string, its parts are used for Host, User and
+	// Port unless the env it is written in sets those.
 	Server string `yaml:"server"`
 
 	// The host to deploy to.
 	Host string `yaml:"host"`
+	// The ssh port of the hosts, defaults to 22.
+	Port int `yaml:"port"`
+	// Additional hosts to deploy to.
+	Hosts []string `yaml:"hosts"`
+	// Name of a group of hosts defined on the gonzalo server to deploy to.
+	Group string `yaml:"group"`
+
+	// How to deploy to multiple hosts: parallel (default) or rolling.
+	Strategy string `yaml:"strategy"`
+	// Amount of hosts deployed to at once with the rolling strategy.
+	// Defaults to 1.
+	BatchSize int `yaml:"batch-size"`
+	// Amount of hosts that are allowed to fail before the deploy is
+	// aborted and considered failed.
+	MaxFailures int `yaml:"max-failures"`
+
 	// The user on the remote server.
 	User string `yaml:"user"`
 
@@ -30,7 +163,10 @@ type Env struct {
 	// Path your repo will be deployed to.
 	Dest string `yaml:"dest"`
 
-	// The minimum role that is allowed to deploy.
+	// The minimum role that is allowed to deploy and roll back:
+	// developer, lead or admin. It can only raise the role set for the env
+	// on the gonzalo server, since whoever deploys picks the commit it is
+	// read from. Any known user may deploy if neither is set.
 	Role Role `yaml:"role"`
 
 	// The chat channel that will receive deployment pings.
@@ -48,26 +184,323 @@ type Env struct {
 	PostUploadCurrent []Command `yaml:"post-upload-current"`
 	PostUploadNext    []Command `yaml:"post-upload-next"`
 	PostDeploy        []Command `yaml:"post-deploy"`
+
+	// Custom variables exported to all commands, next to COMMIT,
+	// SHORT_COMMIT, ENV, DEST, RELEASE_DIR, CURRENT_DIR and DEPLOY_USER.
+	// Commands use them as shell variables, e.g. "${RELEASE_DIR}/web".
+	// $${NAME} is a literal ${NAME}, except inside single quotes where
+	// ${NAME} is not expanded to begin with.
+	// Builds are shared between envs with the same BuildKey so build
+	// commands of those should not depend on env specific values.
+	Vars map[string]string `yaml:"vars"`
+
+	// Checks run after PostDeploy, current is switched back to the
+	// previous release if they fail.
+	Health Health `yaml:"health"`
+
+	// Decrypted secrets, see Project.ConfigEnv.
+	secrets []string
+	// Digest of the recipes the env was loaded with.
+	recipes string
 }
 
-type Config map[string]Env
+const (
+	// Env every other env inherits from, unless it extends another env.
+	baseEnv = "all"
+	// Key naming the env an env inherits from.
+	extendsKey = "extends"
+	// Suffix of list keys that append to the inherited list instead of
+	// replacing it, e.g. build+.
+	appendSuffix = "+"
+)
+
+// Config holds the envs of a .deploy file as they were written.
+// Use GetEnv to resolve an env with the values it inherits.
+type Config map[string]map[interface{}]interface{}
 
+// GetEnv returns the named env merged on top of the env it extends, or on
+// top of the all env if it extends nothing.
+// Maps are merged key by key, other values of the env replace the
+// inherited ones. A list key with a + suffix (e.g. build+) is appended to
+// the inherited list instead.
+// The resolved env is validated, a ConfigErrors is returned if it is
+// invalid.
 func (c Config) GetEnv(name string) (Env, error) {
-	// TODO https://github.com/imdario/mergo
-	env, ok := c[name]
-	//all := c["all"]
-	if !ok {
-		return env, fmt.Errorf("Env %s is not defined", name)
+	env, err := c.env(name)
+	if err != nil {
+		return env, err
+	}
+
+	errs := validate(env).errors()
+	for _, e := range errs {
+		e.Env = name
+	}
+
+	if len(errs) != 0 {
+		return env, errs
 	}
 
 	return env, nil
 }
 
-func decodeFile(f string) (*Config, error) {
-	d, err := ioutil.ReadFile(f)
+func (c Config) env(name string) (Env, error) {
+	var env Env
+	if name == baseEnv {
+		return env, fmt.Errorf("Env %s can not be deployed", baseEnv)
+	}
+
+	raw, err := c.resolve(name, nil)
+	if err != nil {
+		return env, err
+	}
+
+	d, err := yaml.Marshal(raw)
+	if err != nil {
+		return env, err
+	}
+
+	return env, yaml.UnmarshalStrict(d, &env)
+}
+
+// applyServer returns raw with the host, user and port keys it does not
+// set taken from its deprecated server key. They are applied to the env
+// the server is written in so they replace inherited values like any
+// other key of the env.
+func applyServer(raw map[interface{}]interface{}) (
+	map[interface{}]interface{},
+	error,
+) {
+	server, _ := raw["server"].(string)
+	if server == "" {
+		return raw, nil
+	}
+
+	user, host, port, err := parseServer(server)
 	if err != nil {
 		return nil, err
 	}
-	c := &Config{}
-	return c, yaml.Unmarshal(d, c)
+
+	m := make(map[interface{}]interface{}, len(raw)+3)
+	for k, v := range raw {
+		m[k] = v
+	}
+
+	set := func(key string, v interface{}) {
+		if _, ok := m[key]; !ok {
+			m[key] = v
+		}
+	}
+
+	_, hosts := m["hosts"]
+	_, group := m["group"]
+	if !hosts && !group {
+		set("host", host)
+	}
+
+	if user != "" {
+		set("user", user)
+	}
+
+	if port != 0 {
+		set("port", port)
+	}
+
+	return m, nil
+}
+
+// parseServer splits a [user@]host[:port] string.
+func parseServer(server string) (user, host string, port int, err error) {
+	host = server
+	if i := strings.LastIndex(host, "@"); i != -1 {
+		user, host = host[:i], host[i+1:]
+	}
+
+	if h, p, serr := net.SplitHostPort(host); serr == nil {
+		host = h
+		if port, err = strconv.Atoi(p); err != nil {
+			return "", "", 0, fmt.Errorf("Invalid port in server %s", server)
+		}
+	}
+
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if host == "" {
+		return "", "", 0, fmt.Errorf("Invalid server %s", server)
+	}
+
+	return user, host, port, nil
+}
+
+// keep returns the amount of previous releases to keep, -1 keeps all.
+func (e Env) keep() int {
+	if e.Backups == nil || *e.Backups < 0 {
+		return -1
+	}
+
+	return *e.Backups
+}
+
+// Dump returns the yaml of the given env as resolved by GetEnv, before it
+// is decoded, or of all envs if name is empty. Secrets stay encrypted.
+func (c Config) Dump(name string) ([]byte, error) {
+	if name == "" {
+		return yaml.Marshal(c)
+	}
+
+	raw, err := c.resolve(name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(raw)
+}
+
+// parent returns the name of the env the given env inherits from or an
+// empty string if it inherits from none.
+func (c Config) parent(name string) string {
+	switch v := c[name][extendsKey].(type) {
+	case nil:
+		if _, ok := c[baseEnv]; ok && name != baseEnv {
+			return baseEnv
+		}
+	case string:
+		return v
+	}
+
+	return ""
+}
+
+func (c Config) resolve(name string, chain []string) (
+	map[interface{}]interface{},
+	error,
+) {
+	raw, ok := c[name]
+	if !ok {
+		return nil, fmt.Errorf("Env %s is not defined", name)
+	}
+
+	chain = append(chain, name)
+	for _, n := range chain[:len(chain)-1] {
+		if n == name {
+			return nil, fmt.Errorf(
+				"Env %s extends itself: %s",
+				name,
+				strings.Join(chain, " -> "),
+			)
+		}
+	}
+
+	var parent string
+	switch v := raw[extendsKey].(type) {
+	case nil:
+		if _, ok := c[baseEnv]; ok && name != baseEnv {
+			parent = baseEnv
+		}
+	case string:
+		if name == baseEnv {
+			return nil, fmt.Errorf("Env %s can not extend another env", baseEnv)
+		}
+		parent = v
+	default:
+		return nil, fmt.Errorf("Env %s: %s should be an env name", name, extendsKey)
+	}
+
+	base := make(map[interface{}]interface{})
+	if parent != "" {
+		var err error
+		if base, err = c.resolve(parent, chain); err != nil {
+			return nil, err
+		}
+	}
+
+	raw, err := applyServer(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Env %s: %s", name, err)
+	}
+
+	merged, err := merge(base, raw, false)
+	if err != nil {
+		return nil, fmt.Errorf("Env %s: %s", name, err)
+	}
+
+	delete(merged, extendsKey)
+	return merged, nil
+}
+
+// merge returns a copy of base with the values of over merged into it.
+// When keep is true, appends to keys that are not in base are kept as is
+// so they still append to what base is merged on later.
+func merge(base, over map[interface{}]interface{}, keep bool) (
+	map[interface{}]interface{},
+	error,
+) {
+	merged := make(map[interface{}]interface{}, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+
+	for k, v := range over {
+		key, ok := k.(string)
+		if !ok || !strings.HasSuffix(key, appendSuffix) {
+			if ok {
+				delete(merged, key+appendSuffix)
+			}
+
+			m, ok := v.(map[interface{}]interface{})
+			inherited, iok := merged[k].(map[interface{}]interface{})
+			if ok && iok {
+				var err error
+				if merged[k], err = merge(inherited, m, keep); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			merged[k] = v
+			continue
+		}
+
+		list, ok := v.([]interface{})
+		if !ok && v != nil {
+			return nil, fmt.Errorf("%s should be a list", key)
+		}
+
+		name := strings.TrimSuffix(key, appendSuffix)
+		if _, ok := merged[name]; !ok && keep {
+			name = key
+		}
+
+		inherited, ok := merged[name].([]interface{})
+		if !ok && merged[name] != nil {
+			return nil, fmt.Errorf("%s is not a list", name)
+		}
+
+		l := make([]interface{}, 0, len(inherited)+len(list))
+		merged[name] = append(append(l, inherited...), list...)
+	}
+
+	return merged, nil
+}
+
+// mergeConfig merges the envs of over into those of base with the same
+// name.
+func mergeConfig(base, over Config) (Config, error) {
+	merged := make(Config, len(base)+len(over))
+	for name, env := range base {
+		merged[name] = env
+	}
+
+	for name, env := range over {
+		base, ok := merged[name]
+		if !ok {
+			merged[name] = env
+			continue
+		}
+
+		var err error
+		if merged[name], err = merge(base, env, true); err != nil {
+			return nil, fmt.Errorf("Env %s: %s", name, err)
+		}
+	}
+
+	return merged, nil
 }
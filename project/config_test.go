@@ -0,0 +1,270 @@
+package project
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func parse(t *testing.T, s string) map[interface{}]interface{} {
+	m := make(map[interface{}]interface{})
+	if err := yaml.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func parseConfig(t *testing.T, s string) Config {
+	f, err := decode("test", []byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Envs
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name string
+		base string
+		over string
+		keep bool
+		want string
+		err  string
+	}{
+		{
+			name: "scalars replace",
+			base: "user: a\nhost: h",
+			over: "user: b",
+			want: "user: b\nhost: h",
+		},
+		{
+			name: "maps merge by key",
+			base: "vars: {A: a, B: b}",
+			over: "vars: {B: c, C: d}",
+			want: "vars: {A: a, B: c, C: d}",
+		},
+		{
+			name: "lists replace",
+			base: "build: [a, b]",
+			over: "build: [c]",
+			want: "build: [c]",
+		},
+		{
+			name: "append",
+			base: "build: [a]",
+			over: "build+: [b, c]",
+			want: "build: [a, b, c]",
+		},
+		{
+			name: "append to nothing",
+			over: "build+: [b]",
+			want: "build: [b]",
+		},
+		{
+			name: "append to nothing keeps the suffix",
+			over: "build+: [b]",
+			keep: true,
+			want: "build+: [b]",
+		},
+		{
+			name: "appends to a kept append",
+			base: "build+: [a]",
+			over: "build+: [b]",
+			keep: true,
+			want: "build+: [a, b]",
+		},
+		{
+			name: "replace drops a kept append",
+			base: "build+: [a]",
+			over: "build: [b]",
+			keep: true,
+			want: "build: [b]",
+		},
+		{
+			name: "append in nested map",
+			base: "health: {commands: [a]}",
+			over: "health: {commands+: [b]}",
+			want: "health: {commands: [a, b]}",
+		},
+		{
+			name: "append of a non list",
+			over: "build+: a",
+			err:  "build+ should be a list",
+		},
+		{
+			name: "append to a non list",
+			base: "build: a",
+			over: "build+: [b]",
+			err:  "build is not a list",
+		},
+	}
+
+	for _, test := range tests {
+		base := parse(t, test.base)
+		got, err := merge(base, parse(t, test.over), test.keep)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: expected error '%s', got %v", test.name, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if want := parse(t, test.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v", test.name, want, got)
+		}
+
+		if !reflect.DeepEqual(base, parse(t, test.base)) {
+			t.Errorf("%s: base was modified", test.name)
+		}
+	}
+}
+
+func TestMergeConfig(t *testing.T) {
+	included := parseConfig(t, `
+all:
+  build: [a]
+prod:
+  build+: [b]
+  vars: {A: a}
+`)
+	file := parseConfig(t, `
+prod:
+  build+: [c]
+  vars: {B: b}
+staging:
+  host: s
+`)
+
+	got, err := mergeConfig(included, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := parseConfig(t, `
+all:
+  build: [a]
+prod:
+  build+: [b, c]
+  vars: {A: a, B: b}
+staging:
+  host: s
+`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	env, err := got.env("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := []Command{{Run: "a"}, {Run: "b"}, {Run: "c"}}
+	if !reflect.DeepEqual(env.Build, build) {
+		t.Errorf("expected build %v, got %v", build, env.Build)
+	}
+}
+
+func TestConfigEnv(t *testing.T) {
+	c := parseConfig(t, `
+all:
+  user: www
+  build: [a]
+  vars: {A: a}
+prod:
+  host: p
+  build+: [b]
+  vars: {B: b}
+staging:
+  extends: prod
+  host: s
+  build: [c]
+legacy:
+  server: deploy@l:2222
+  port: 22
+loop-a:
+  extends: loop-b
+loop-b:
+  extends: loop-a
+missing:
+  extends: nope
+`)
+
+	tests := []struct {
+		name string
+		want Env
+		err  string
+	}{
+		{
+			name: "prod",
+			want: Env{
+				User:  "www",
+				Host:  "p",
+				Build: []Command{{Run: "a"}, {Run: "b"}},
+				Vars:  map[string]string{"A": "a", "B": "b"},
+			},
+		},
+		{
+			name: "staging",
+			want: Env{
+				User:  "www",
+				Host:  "s",
+				Build: []Command{{Run: "c"}},
+				Vars:  map[string]string{"A": "a", "B": "b"},
+			},
+		},
+		{
+			name: "legacy",
+			want: Env{
+				Server: "deploy@l:2222",
+				User:   "deploy",
+				Host:   "l",
+				Port:   22,
+				Build:  []Command{{Run: "a"}},
+				Vars:   map[string]string{"A": "a"},
+			},
+		},
+		{name: "all", err: "Env all can not be deployed"},
+		{name: "nope", err: "Env nope is not defined"},
+		{name: "missing", err: "Env nope is not defined"},
+		{
+			name: "loop-a",
+			err:  "Env loop-a extends itself: loop-a -> loop-b -> loop-a",
+		},
+	}
+
+	for _, test := range tests {
+		env, err := c.env(test.name)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: expected error '%s', got %v", test.name, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(env, test.want) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.want, env)
+		}
+	}
+}
+
+func TestConfigAllExtends(t *testing.T) {
+	c := parseConfig(t, "all:\n  extends: prod\nprod:\n  host: p\n")
+	_, err := c.env("prod")
+	if err == nil || !strings.Contains(err.Error(), "can not extend") {
+		t.Errorf("expected all to not be able to extend, got %v", err)
+	}
+}
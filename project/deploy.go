@@ -0,0 +1,655 @@
+package project
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frizinak/gonzalo/events"
+	"github.com/frizinak/gonzalo/ssh/sshconn"
+	"github.com/frizinak/gonzalo/stores"
+)
+
+type Phase string
+
+const (
+	PhaseBuild             Phase = "build"
+	PhaseBackup            Phase = "backup"
+	PhaseRequired          Phase = "required"
+	PhasePreUpload         Phase = "pre-upload"
+	PhaseUpload            Phase = "upload"
+	PhaseDuringUpload      Phase = "during-upload"
+	PhasePostUploadCurrent Phase = "post-upload-current"
+	PhasePostUploadNext    Phase = "post-upload-next"
+	PhaseSwitch            Phase = "switch"
+	PhasePostDeploy        Phase = "post-deploy"
+	PhaseHealth            Phase = "health"
+	PhasePrune             Phase = "prune"
+)
+
+// CommandResult holds the output of a single command.
+type CommandResult struct {
+	Command Command
+	Stdout  []byte
+	Stderr  []byte
+	Err     error
+}
+
+// PhaseResult holds the outcome of a single deploy phase.
+type PhaseResult struct {
+	Phase    Phase
+	Start    time.Time
+	End      time.Time
+	Commands []*CommandResult
+	Err      error
+}
+
+// Result is the outcome of a deploy.
+type Result struct {
+	ID        string
+	User      string
+	Commitish string
+	Commit    string
+	Env       string
+	Start     time.Time
+	End       time.Time
+	Err       error
+
+	// Phases that run once, on the gonzalo machine.
+	Phases []*PhaseResult
+	// Outcome per host, in the order they were deployed to.
+	Hosts []*HostResult
+
+	// The release created by this deploy.
+	Release string
+
+	// Output of the Env.Backup commands.
+	Backups []*stores.Artifact
+
+	// Whether this was a rollback to an existing release.
+	Rollback bool
+
+	// Whether the build of another env with the same BuildKey was reused.
+	BuildReused bool
+}
+
+// Phase returns the result of the given local phase or nil if it did not
+// run.
+func (r *Result) Phase(phase Phase) *PhaseResult {
+	return findPhase(r.Phases, phase)
+}
+
+// Host returns the result of the given host or nil if it was not
+// deployed to.
+func (r *Result) Host(host string) *HostResult {
+	for _, h := range r.Hosts {
+		if h.Host == host {
+			return h
+		}
+	}
+
+	return nil
+}
+
+// RolledBack reports whether any host was switched back to its previous
+// release because its health check failed.
+func (r *Result) RolledBack() bool {
+	for _, h := range r.Hosts {
+		if h.RolledBack {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Failed returns the hosts that failed. A deploy that succeeds can still
+// have failed hosts if the env allows them with MaxFailures.
+func (r *Result) Failed() []*HostResult {
+	var list []*HostResult
+	for _, h := range r.Hosts {
+		if h.Err != nil {
+			list = append(list, h)
+		}
+	}
+
+	return list
+}
+
+func findPhase(phases []*PhaseResult, phase Phase) *PhaseResult {
+	for _, p := range phases {
+		if p.Phase == phase {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// PhaseError is returned when a phase fails, no further phases are run.
+type PhaseError struct {
+	Phase   Phase
+	Command Command
+	Err     error
+}
+
+func (e *PhaseError) Error() string {
+	if e.Command.Run == "" {
+		return fmt.Sprintf("Phase %s failed: %s", e.Phase, e.Err)
+	}
+
+	return fmt.Sprintf(
+		"Phase %s failed on '%s': %s",
+		e.Phase,
+		e.Command,
+		e.Err,
+	)
+}
+
+type step struct {
+	phase Phase
+	run   func(context.Context, *deploy, *PhaseResult) error
+}
+
+// stage is a group of steps that run concurrently.
+type stage []step
+
+type deploy struct {
+	project   *Project
+	env       Env
+	result    *Result
+	workspace string
+	build     string
+	// The cached build in use, if any.
+	cache  string
+	events *events.Stream
+
+	// Set for deploys to a single host, see forHost.
+	host     *HostResult
+	conn     *sshconn.Connection
+	releases *releases
+	primary  bool
+
+	// Hides the secrets of env, nil if there are none.
+	masker *strings.Replacer
+}
+
+// Deploy resolves the env at the given commitish, builds it and runs all
+// deploy phases in order on each of the env's hosts. The pipeline of a
+// host stops at its first failing phase. A host whose health check fails
+// is rolled back to its previous release.
+// A *ForbiddenError is returned if user does not have the Role of the env.
+// If another deploy of the env is in progress a *lock.InUseError is
+// returned, unless wait is true in which case the deploy is queued.
+// Cancelling ctx stops waiting for the lock, kills running commands,
+// removes the new release if it did not become current yet and releases
+// all locks.
+func (p *Project) Deploy(
+	ctx context.Context,
+	user, commitish, env string,
+	wait bool,
+) (*Result, error) {
+	res := &Result{User: user, Commitish: commitish, Env: env}
+	return p.execute(res, func(res *Result, s *events.Stream) error {
+		return p.deploy(ctx, res, s, wait)
+	})
+}
+
+func (p *Project) deploy(
+	ctx context.Context,
+	res *Result,
+	s *events.Stream,
+	wait bool,
+) error {
+	env, commit, err := p.configEnv(ctx, res.Commitish, res.Env)
+	if err != nil {
+		return err
+	}
+
+	res.Commit = commit
+
+	if err := p.authorize(res.User, res.Env, env); err != nil {
+		return err
+	}
+
+	if env.Dest == "" {
+		return errors.New("No dest specified")
+	}
+
+	hosts, err := p.hosts(env)
+	if err != nil {
+		return err
+	}
+
+	if err := p.checkRequired(ctx, res.Commit, env); err != nil {
+		return err
+	}
+
+	unlock, err := p.locks.Acquire(ctx, p.lockKey(res.Env), res.User, wait)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	defer p.streams.Register(p.lockKey(res.Env), s)()
+
+	d := &deploy{
+		project:   p,
+		env:       env,
+		result:    res,
+		workspace: p.workspace(res.ID),
+		events:    s,
+		masker:    newMasker(env),
+	}
+	defer d.cleanWorkspace()
+
+	res.Release = res.ID
+	if err := d.run(ctx, []stage{{{PhaseBuild, build}}}); err != nil {
+		return err
+	}
+
+	return d.onHosts(ctx, hosts, deployHost)
+}
+
+// deployHost runs the deploy pipeline on a single host.
+func deployHost(ctx context.Context, d *deploy) error {
+	id := d.result.Release
+	if err := d.releases.Begin(ctx, id); err != nil {
+		return err
+	}
+
+	// Cleanup has to happen even if ctx was cancelled.
+	defer d.releases.End(context.Background(), id)
+
+	err := d.run(ctx, pipeline())
+	switch {
+	case err == nil:
+	case !d.switched():
+		d.releases.Remove(context.Background(), id)
+	case d.unhealthy():
+		if rerr := d.revert(); rerr != nil {
+			return fmt.Errorf("%s, rollback failed: %s", err, rerr)
+		}
+	}
+
+	return err
+}
+
+// switched reports whether current was pointed to the new release.
+func (d *deploy) switched() bool {
+	pr := d.host.Phase(PhaseSwitch)
+	return pr != nil && pr.Err == nil && !pr.End.IsZero()
+}
+
+// Length of SHORT_COMMIT.
+const shortCommit = 7
+
+// Matches the escaped variable references $${NAME}.
+var escapedVarRE = regexp.MustCompile(`\$\$\{[A-Za-z_][A-Za-z0-9_]*\}`)
+
+// newID returns a unique, chronologically sortable deploy id.
+func newID(t time.Time) string {
+	rnd := make([]byte, 3)
+	rand.Read(rnd)
+	return t.UTC().Format("20060102150405") + "-" + hex.EncodeToString(rnd)
+}
+
+// pipeline returns the phases that run on each host.
+func pipeline() []stage {
+	return []stage{
+		{{PhaseBackup, backup}},
+		{{PhaseRequired, required}},
+		{{PhasePreUpload, remote(preUpload, current)}},
+		{
+			{PhaseUpload, upload},
+			{PhaseDuringUpload, remote(duringUpload, current)},
+		},
+		{{PhasePostUploadCurrent, remote(postUploadCurrent, current)}},
+		{{PhasePostUploadNext, remote(postUploadNext, next)}},
+		{{PhaseSwitch, switchRelease}},
+		{{PhasePostDeploy, remote(postDeploy, current)}},
+		{{PhaseHealth, health}},
+		{{PhasePrune, prune}},
+	}
+}
+
+func preUpload(d *deploy) []Command      { return d.env.PreUpload }
+func duringUpload(d *deploy) []Command   { return d.env.DuringUpload }
+func postUploadNext(d *deploy) []Command { return d.env.PostUploadNext }
+func postDeploy(d *deploy) []Command     { return d.env.PostDeploy }
+
+// postUploadCurrent is skipped when there is no current release yet.
+func postUploadCurrent(d *deploy) []Command {
+	if d.host.Previous == "" {
+		return nil
+	}
+
+	return d.env.PostUploadCurrent
+}
+
+// current returns the working directory for commands that operate on the
+// live release, falling back to Dest on a first deploy.
+func current(d *deploy) string {
+	if d.host.Previous == "" && d.host.Phase(PhaseSwitch) == nil {
+		return d.env.Dest
+	}
+
+	return d.releases.CurrentDir()
+}
+
+// next returns the working directory of the release being deployed.
+func next(d *deploy) string {
+	return d.releases.Dir(d.result.Release)
+}
+
+func (d *deploy) run(ctx context.Context, stages []stage) error {
+	for _, st := range stages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := d.stage(ctx, st); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stage runs all steps of st concurrently, waits for all of them to finish
+// and returns the first error. The first failing step cancels the others.
+func (d *deploy) stage(ctx context.Context, st stage) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	phases := &d.result.Phases
+	if d.host != nil {
+		phases = &d.host.Phases
+	}
+
+	prs := make([]*PhaseResult, len(st))
+	for i := range st {
+		prs[i] = &PhaseResult{Phase: st[i].phase, Start: time.Now()}
+		*phases = append(*phases, prs[i])
+	}
+
+	var first error
+	var once sync.Once
+	var wg sync.WaitGroup
+	for i := range st {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := d.step(ctx, st[i], prs[i]); err != nil {
+				once.Do(func() {
+					first = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return first
+}
+
+func (d *deploy) step(ctx context.Context, s step, pr *PhaseResult) error {
+	var host string
+	if d.host != nil {
+		host = d.host.Host
+	}
+
+	d.events.Publish(events.Event{
+		Time:  pr.Start,
+		Type:  events.PhaseStarted,
+		Phase: string(s.phase),
+		Host:  host,
+	})
+
+	err := s.run(ctx, d, pr)
+	pr.End = time.Now()
+	finished := events.Event{
+		Time:  pr.End,
+		Type:  events.PhaseFinished,
+		Phase: string(s.phase),
+		Host:  host,
+	}
+
+	if err != nil {
+		if _, ok := err.(*PhaseError); !ok {
+			err = &PhaseError{Phase: s.phase, Err: err}
+		}
+
+		pr.Err = err
+		finished.Err = err.Error()
+	}
+
+	d.events.Publish(finished)
+	return err
+}
+
+func upload(ctx context.Context, d *deploy, pr *PhaseResult) error {
+	src, err := root(d.build, d.env)
+	if err != nil {
+		return err
+	}
+
+	n, err := d.conn.Upload(ctx, src, d.releases.Dir(d.result.Release))
+	d.host.Uploaded += n
+	return err
+}
+
+func switchRelease(ctx context.Context, d *deploy, pr *PhaseResult) error {
+	if err := d.releases.Switch(ctx, d.result.Release); err != nil {
+		return err
+	}
+
+	action := actionDeploy
+	if d.result.Rollback {
+		action = actionRollback
+	}
+
+	return d.project.record(d.result.Env, entry{
+		Time:     time.Now(),
+		Action:   action,
+		Host:     d.host.Host,
+		Release:  d.result.Release,
+		Previous: d.host.Previous,
+		Commit:   d.result.Commit,
+	})
+}
+
+func prune(ctx context.Context, d *deploy, pr *PhaseResult) error {
+	pruned, err := d.releases.Prune(ctx, d.result.Release, d.env.keep())
+	d.host.Pruned = pruned
+	for _, id := range pruned {
+		e := entry{
+			Time:    time.Now(),
+			Action:  actionPrune,
+			Host:    d.host.Host,
+			Release: id,
+		}
+		if rerr := d.project.record(d.result.Env, e); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+
+	return err
+}
+
+func remote(
+	cmds func(*deploy) []Command,
+	dir func(*deploy) string,
+) func(context.Context, *deploy, *PhaseResult) error {
+	return func(ctx context.Context, d *deploy, pr *PhaseResult) error {
+		for _, cmd := range cmds(d) {
+			if err := d.command(ctx, pr, dir(d), cmd, OnRemote); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func (d *deploy) remote(
+	ctx context.Context,
+	pr *PhaseResult,
+	dir string,
+	cmd Command,
+) error {
+	var stdout, stderr bytes.Buffer
+	outw, errw := d.output(pr, d.host.Host)
+	err := d.conn.Stream(
+		ctx,
+		d.shell(dir, cmd),
+		nil,
+		io.MultiWriter(&stdout, outw),
+		io.MultiWriter(&stderr, errw),
+	)
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	outw.Flush()
+	errw.Flush()
+
+	return d.recordCommand(pr, cmd, stdout.Bytes(), stderr.Bytes(), err)
+}
+
+// recordCommand adds the outcome of cmd to pr, with the secrets of the env
+// masked, and returns a *PhaseError if it failed.
+func (d *deploy) recordCommand(
+	pr *PhaseResult,
+	cmd Command,
+	stdout, stderr []byte,
+	err error,
+) error {
+	cmd = d.maskCommand(cmd)
+	stdout = []byte(d.mask(string(stdout)))
+	stderr = []byte(d.mask(string(stderr)))
+	pr.Commands = append(
+		pr.Commands,
+		&CommandResult{cmd, stdout, stderr, err},
+	)
+
+	if err != nil {
+		return &PhaseError{
+			Phase:   pr.Phase,
+			Command: cmd,
+			Err:     cmdError(err, stderr),
+		}
+	}
+
+	return nil
+}
+
+// output returns writers that publish stdout and stderr of a command
+// running on host in the given phase, with the secrets of the env masked.
+func (d *deploy) output(pr *PhaseResult, host string) (
+	stdout,
+	stderr *events.LineWriter,
+) {
+	phase := string(pr.Phase)
+	stdout = events.NewLineWriter(d.events, phase, host, events.Stdout)
+	stderr = events.NewLineWriter(d.events, phase, host, events.Stderr)
+	stdout.SetFilter(d.mask)
+	stderr.SetFilter(d.mask)
+
+	return stdout, stderr
+}
+
+// vars returns the variables exported to commands.
+func (d *deploy) vars() map[string]string {
+	r := d.releases
+	if r == nil {
+		r = newReleases(nil, d.env.Dest)
+	}
+
+	vars := make(map[string]string, len(d.env.Vars)+7)
+	for k, v := range d.env.Vars {
+		vars[k] = v
+	}
+
+	short := d.result.Commit
+	if len(short) > shortCommit {
+		short = short[:shortCommit]
+	}
+
+	vars["COMMIT"] = d.result.Commit
+	vars["SHORT_COMMIT"] = short
+	vars["ENV"] = d.result.Env
+	vars["DEST"] = d.env.Dest
+	vars["RELEASE_DIR"] = r.Dir(d.result.Release)
+	vars["CURRENT_DIR"] = r.CurrentDir()
+	vars["DEPLOY_USER"] = d.result.User
+
+	return vars
+}
+
+// cmdVars returns the vars of a deploy together with the Env of cmd.
+func (d *deploy) cmdVars(cmd Command) map[string]string {
+	vars := d.vars()
+	for k, v := range cmd.Env {
+		vars[k] = v
+	}
+
+	return vars
+}
+
+// shell wraps cmd so it runs in dir with vars exported.
+func (d *deploy) shell(dir string, cmd Command) string {
+	vars := d.cmdVars(cmd)
+	exports := make([]string, 0, len(vars))
+	for _, k := range sortedKeys(vars) {
+		exports = append(exports, k+"="+sshconn.Quote(vars[k]))
+	}
+
+	return fmt.Sprintf(
+		"export %s && cd %s && %s",
+		strings.Join(exports, " "),
+		sshconn.Quote(dir),
+		expand(cmd.Run),
+	)
+}
+
+// expand prepares cmd to be run by sh. ${NAME} is left for the shell to
+// expand, all vars are exported so they can be used like any other
+// variable, e.g. inside double quotes. $${NAME} is escaped to \${NAME}
+// which the shell reads as a literal ${NAME}. Inside single quotes nothing
+// is expanded, so ${NAME} is already literal there and $${NAME} keeps the
+// backslash.
+func expand(cmd string) string {
+	return escapedVarRE.ReplaceAllStringFunc(
+		cmd,
+		func(m string) string {
+			return `\` + m[1:]
+		},
+	)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func cmdError(err error, stderr []byte) error {
+	msg := strings.TrimSpace(string(stderr))
+	if err == nil || msg == "" {
+		return err
+	}
+
+	return fmt.Errorf("%s: %s", err, msg)
+}
@@ -0,0 +1,56 @@
+package project
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"", ""},
+		{"drush cr", "drush cr"},
+		{`cd "${RELEASE_DIR}/web"`, `cd "${RELEASE_DIR}/web"`},
+		{"echo $COMMIT ${1} ${}", "echo $COMMIT ${1} ${}"},
+		{"echo $${COMMIT}", `echo \${COMMIT}`},
+		{"echo $${A} ${A} $${B_2}", `echo \${A} ${A} \${B_2}`},
+		{"echo $${1}", "echo $${1}"},
+	}
+
+	for _, test := range tests {
+		if got := expand(test.cmd); got != test.want {
+			t.Errorf("%s: expected %s, got %s", test.cmd, test.want, got)
+		}
+	}
+}
+
+func TestExpandShell(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{`printf %s "${A}/web"`, "/d/it's a dir/web"},
+		{`printf %s "${B}"`, "$(touch x)"},
+		{`printf %s "$${A}"`, "${A}"},
+		{`printf %s $${A}`, "${A}"},
+		// Single quotes do not expand, the escape is not needed there.
+		{`printf %s '${A}'`, "${A}"},
+		{`printf %s '$${A}'`, `\${A}`},
+	}
+
+	for _, test := range tests {
+		c := exec.Command("sh", "-c", expand(test.cmd))
+		c.Env = []string{"A=/d/it's a dir", "B=$(touch x)"}
+		out, err := c.Output()
+		if err != nil {
+			t.Errorf("%s: %s", test.cmd, err)
+			continue
+		}
+
+		if string(out) != test.want {
+			t.Errorf("%s: expected %s, got %s", test.cmd, test.want, out)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const (
+	defaultHealthInterval = 5 * time.Second
+	defaultHealthTimeout  = 10 * time.Second
+)
+
+// health runs the Env.Health checks, retrying them until they pass or
+// Health.Retries is exhausted.
+func health(ctx context.Context, d *deploy, pr *PhaseResult) error {
+	h := d.env.Health
+	if h.URL == "" && len(h.Commands) == 0 {
+		return nil
+	}
+
+	interval := defaultHealthInterval
+	if h.Interval > 0 {
+		interval = time.Duration(h.Interval) * time.Second
+	}
+
+	for i := 0; ; i++ {
+		err := d.healthCheck(ctx, pr)
+		if err == nil || i >= h.Retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (d *deploy) healthCheck(ctx context.Context, pr *PhaseResult) error {
+	for _, cmd := range d.env.Health.Commands {
+		if err := d.command(ctx, pr, current(d), cmd, OnRemote); err != nil {
+			return err
+		}
+	}
+
+	if d.env.Health.URL == "" {
+		return nil
+	}
+
+	body, err := d.probe(ctx)
+	if err != nil {
+		err = errors.New(d.mask(err.Error()))
+	}
+
+	cmd := Command{Run: "GET " + d.env.Health.URL}
+	return d.recordCommand(pr, cmd, body, nil, err)
+}
+
+// probe requests Health.URL and checks the response status and body.
+func (d *deploy) probe(ctx context.Context) ([]byte, error) {
+	h := d.env.Health
+	timeout := defaultHealthTimeout
+	if h.Timeout > 0 {
+		timeout = time.Duration(h.Timeout) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", h.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return body, err
+	}
+
+	status := h.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if res.StatusCode != status {
+		return body, fmt.Errorf(
+			"Expected status %d, got %d",
+			status,
+			res.StatusCode,
+		)
+	}
+
+	if h.Body == "" {
+		return body, nil
+	}
+
+	re, err := regexp.Compile(h.Body)
+	if err != nil {
+		return body, err
+	}
+
+	if !re.Match(body) {
+		return body, fmt.Errorf("Body does not match %s", h.Body)
+	}
+
+	return body, nil
+}
+
+// unhealthy reports whether the health check of the new release failed.
+func (d *deploy) unhealthy() bool {
+	pr := d.host.Phase(PhaseHealth)
+	return pr != nil && pr.Err != nil
+}
+
+// revert points current back to the release that was current before the
+// deploy. The commands of the previous release are not run again.
+func (d *deploy) revert() error {
+	if d.host.Previous == "" {
+		return errors.New("No previous release to roll back to")
+	}
+
+	// The deploy might have been cancelled during the health check.
+	ctx := context.Background()
+	if err := d.releases.Switch(ctx, d.host.Previous); err != nil {
+		return err
+	}
+
+	d.host.RolledBack = true
+	commit, _ := d.project.releaseCommit(d.result.Env, d.host.Previous)
+	return d.project.record(d.result.Env, entry{
+		Time:     time.Now(),
+		Action:   actionRollback,
+		Host:     d.host.Host,
+		Release:  d.host.Previous,
+		Previous: d.result.Release,
+		Commit:   commit,
+	})
+}
@@ -0,0 +1,119 @@
+package project
+
+import (
+	"time"
+
+	"github.com/frizinak/gonzalo/events"
+	"github.com/frizinak/gonzalo/history"
+)
+
+// History returns at most limit recorded deploys of the env, most recent
+// first.
+func (p *Project) History(env string, limit int) ([]*history.Deploy, error) {
+	return p.history.List(p.repo.Name(), env, limit)
+}
+
+// execute records res in the history before and after running it and
+// publishes its start and end on a new event stream.
+func (p *Project) execute(
+	res *Result,
+	run func(*Result, *events.Stream) error,
+) (*Result, error) {
+	res.Start = time.Now()
+	res.ID = newID(res.Start)
+	if err := p.history.Save(p.historyDeploy(res)); err != nil {
+		return res, err
+	}
+
+	stream := events.NewStream(res.ID)
+	defer stream.Close()
+	stream.Publish(events.Event{Time: res.Start, Type: events.DeployStarted})
+
+	err := run(res, stream)
+	res.End = time.Now()
+	res.Err = err
+	if herr := p.history.Save(p.historyDeploy(res)); herr != nil && err == nil {
+		err = herr
+	}
+
+	finished := events.Event{Time: res.End, Type: events.DeployFinished}
+	if err != nil {
+		finished.Err = err.Error()
+	}
+	stream.Publish(finished)
+
+	return res, err
+}
+
+func (p *Project) historyDeploy(res *Result) *history.Deploy {
+	d := &history.Deploy{
+		ID:        res.ID,
+		Project:   p.repo.Name(),
+		Env:       res.Env,
+		Commitish: res.Commitish,
+		Commit:    res.Commit,
+		User:      res.User,
+		Rollback:  res.Rollback,
+		Release:   res.Release,
+		Start:     res.Start,
+		End:       res.End,
+		Phases:    historyPhases(res.Phases),
+		Hosts:     make([]history.Host, len(res.Hosts)),
+		Outcome:   history.OutcomeSuccess,
+	}
+
+	for i, h := range res.Hosts {
+		d.Hosts[i] = history.Host{
+			Host:       h.Host,
+			Previous:   h.Previous,
+			Pruned:     h.Pruned,
+			Phases:     historyPhases(h.Phases),
+			RolledBack: h.RolledBack,
+		}
+
+		if h.Err != nil {
+			d.Hosts[i].Err = h.Err.Error()
+		}
+	}
+
+	if res.Err != nil {
+		d.Err = res.Err.Error()
+	}
+
+	switch {
+	case res.End.IsZero():
+		d.Outcome = history.OutcomeRunning
+	case res.RolledBack():
+		d.Outcome = history.OutcomeRolledBack
+	case isForbidden(res.Err):
+		d.Outcome = history.OutcomeDenied
+	case res.Err != nil:
+		d.Outcome = history.OutcomeFailed
+	case len(res.Failed()) != 0:
+		d.Outcome = history.OutcomePartial
+	}
+
+	return d
+}
+
+func historyPhases(phases []*PhaseResult) []history.Phase {
+	list := make([]history.Phase, len(phases))
+	for i, ph := range phases {
+		list[i] = history.Phase{
+			Name:  string(ph.Phase),
+			Start: ph.Start,
+			End:   ph.End,
+		}
+
+		if ph.Err != nil {
+			list[i].Err = ph.Err.Error()
+		}
+	}
+
+	return list
+}
+
+func isForbidden(err error) bool {
+	_, ok := err.(*ForbiddenError)
+	return ok
+}
@@ -0,0 +1,208 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	StrategyParallel = "parallel"
+	StrategyRolling  = "rolling"
+)
+
+// HostResult is the outcome of a deploy on a single host.
+type HostResult struct {
+	Host string
+	// The release that was current before the deploy.
+	Previous string
+	Phases   []*PhaseResult
+	// Bytes uploaded to the host.
+	Uploaded int64
+	// Releases removed from the host.
+	Pruned []string
+	// Whether current was switched back to Previous because the health
+	// check failed.
+	RolledBack bool
+	Err        error
+}
+
+// Phase returns the result of the given phase or nil if it did not run.
+func (h *HostResult) Phase(phase Phase) *PhaseResult {
+	return findPhase(h.Phases, phase)
+}
+
+// HostsError is returned when more hosts failed than Env.MaxFailures
+// allows.
+type HostsError struct {
+	Failed []*HostResult
+	Total  int
+}
+
+func (e *HostsError) Error() string {
+	if len(e.Failed) == 1 && e.Total == 1 {
+		return e.Failed[0].Err.Error()
+	}
+
+	hosts := make([]string, len(e.Failed))
+	for i, h := range e.Failed {
+		hosts[i] = fmt.Sprintf("%s: %s", h.Host, h.Err)
+	}
+
+	return fmt.Sprintf(
+		"%d of %d hosts failed: %s",
+		len(e.Failed),
+		e.Total,
+		strings.Join(hosts, "; "),
+	)
+}
+
+// hosts returns the Host, Hosts and hosts of the Group of the env.
+func (p *Project) hosts(env Env) ([]string, error) {
+	list := make([]string, 0, 1+len(env.Hosts))
+	if env.Host != "" {
+		list = append(list, env.Host)
+	}
+	list = append(list, env.Hosts...)
+
+	if env.Group != "" {
+		group, ok := p.groups[env.Group]
+		if !ok {
+			return nil, fmt.Errorf("Host group %s is not defined", env.Group)
+		}
+		list = append(list, group...)
+	}
+
+	seen := make(map[string]bool, len(list))
+	hosts := make([]string, 0, len(list))
+	for _, h := range list {
+		if h == "" || seen[h] {
+			continue
+		}
+		seen[h] = true
+		hosts = append(hosts, h)
+	}
+
+	if len(hosts) == 0 {
+		return nil, errors.New("No host specified")
+	}
+
+	return hosts, nil
+}
+
+// port returns the ssh port of the env's hosts.
+func port(env Env) int {
+	if env.Port == 0 {
+		return defaultPort
+	}
+
+	return env.Port
+}
+
+// batches splits hosts into the groups that are deployed to at once.
+func batches(hosts []string, env Env) ([][]string, error) {
+	switch env.Strategy {
+	case "", StrategyParallel:
+		return [][]string{hosts}, nil
+	case StrategyRolling:
+	default:
+		return nil, fmt.Errorf("Unknown strategy %s", env.Strategy)
+	}
+
+	size := env.BatchSize
+	if size < 1 {
+		size = 1
+	}
+
+	list := make([][]string, 0, (len(hosts)+size-1)/size)
+	for len(hosts) > size {
+		list = append(list, hosts[:size])
+		hosts = hosts[size:]
+	}
+
+	return append(list, hosts), nil
+}
+
+// onHosts runs fn for every host, batch by batch. The hosts of a batch
+// run concurrently. Batches that follow are skipped once more than
+// Env.MaxFailures hosts failed.
+func (d *deploy) onHosts(
+	ctx context.Context,
+	hosts []string,
+	fn func(context.Context, *deploy) error,
+) error {
+	list, err := batches(hosts, d.env)
+	if err != nil {
+		return err
+	}
+
+	var failed []*HostResult
+	for i, batch := range list {
+		results := make([]*HostResult, len(batch))
+		var wg sync.WaitGroup
+		for j, host := range batch {
+			results[j] = &HostResult{Host: host}
+			d.result.Hosts = append(d.result.Hosts, results[j])
+
+			wg.Add(1)
+			go func(hd *deploy) {
+				defer wg.Done()
+				hd.host.Err = hd.onHost(ctx, fn)
+			}(d.forHost(results[j], i == 0 && j == 0))
+		}
+		wg.Wait()
+
+		for _, h := range results {
+			if h.Err != nil {
+				failed = append(failed, h)
+			}
+		}
+
+		if len(failed) > d.env.MaxFailures {
+			return &HostsError{Failed: failed, Total: len(hosts)}
+		}
+	}
+
+	return nil
+}
+
+// forHost returns a copy of d that deploys to the given host.
+// Backups are only made on the primary host.
+func (d *deploy) forHost(host *HostResult, primary bool) *deploy {
+	hd := *d
+	hd.host = host
+	hd.primary = primary
+	return &hd
+}
+
+// onHost connects to the host, takes its remote lock and runs fn.
+func (d *deploy) onHost(
+	ctx context.Context,
+	fn func(context.Context, *deploy) error,
+) error {
+	conn, err := d.project.connection(
+		ctx,
+		d.host.Host,
+		d.env.User,
+		port(d.env),
+	)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := remoteLock(ctx, conn, d.env.Dest, d.result.User)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	d.conn = conn
+	d.releases = newReleases(conn, d.env.Dest)
+	if d.host.Previous, err = d.releases.Current(ctx); err != nil {
+		return err
+	}
+
+	return fn(ctx, d)
+}
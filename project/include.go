@@ -0,0 +1,196 @@
+package project
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Includes starting with this prefix name a recipe instead of a file in
+// the repo, e.g. recipe:drupal8.
+const recipePrefix = "recipe:"
+
+// configFile is a decoded .deploy, included or recipe file.
+// The envs of a file are merged on top of those of its includes, which
+// are merged in order. Lists replace the included ones unless their key
+// has the append suffix.
+type configFile struct {
+	Include []string `yaml:"include"`
+	Envs    Config   `yaml:",inline"`
+}
+
+// includer loads config files and their includes.
+type includer struct {
+	// Directory paths of included files are relative to.
+	repo string
+	// Directory of the server side recipes, <name>.yml.
+	recipes string
+	// Checksums of the recipes that were loaded.
+	loaded map[string][sha256.Size]byte
+	// Files that were loaded, in the order they were merged.
+	files []source
+}
+
+// source is a loaded config file.
+type source struct {
+	name string
+	data []byte
+}
+
+// load decodes the file fn, named name in errors, and merges it on top of
+// its includes.
+func (in *includer) load(name, fn string, chain []string) (Config, error) {
+	for _, n := range chain {
+		if n == name {
+			return nil, fmt.Errorf(
+				"%s includes itself: %s",
+				name,
+				strings.Join(append(chain, name), " -> "),
+			)
+		}
+	}
+	chain = append(chain, name)
+
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(name, recipePrefix) {
+		if in.loaded == nil {
+			in.loaded = make(map[string][sha256.Size]byte)
+		}
+		in.loaded[name] = sha256.Sum256(data)
+	}
+
+	f, err := decode(name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Config{}
+	for _, inc := range f.Include {
+		if strings.HasPrefix(name, recipePrefix) &&
+			!strings.HasPrefix(inc, recipePrefix) {
+			return nil, fmt.Errorf(
+				"%s: recipes can only include other recipes, not %s",
+				name,
+				inc,
+			)
+		}
+
+		incfn, err := in.path(inc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+
+		ic, err := in.load(inc, incfn, chain)
+		if err != nil {
+			return nil, err
+		}
+
+		if c, err = mergeConfig(c, ic); err != nil {
+			return nil, fmt.Errorf("%s: %s", inc, err)
+		}
+	}
+
+	c, err = mergeConfig(c, f.Envs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	in.files = append(in.files, source{name, data})
+	return c, nil
+}
+
+// locate sets the file, line and column of errs of the loaded config c.
+func (in *includer) locate(c Config, errs ConfigErrors) {
+	for _, e := range errs {
+		e.File, e.Line, e.Column = in.position(c, e.Env, e.Key)
+	}
+}
+
+// position returns the file, line and column of key in env, or in the env
+// it inherits the key from, in the file it was last merged from.
+// Falls back to the position of env itself.
+func (in *includer) position(c Config, env, key string) (
+	file string,
+	line, col int,
+) {
+	if len(in.files) == 0 {
+		return "", 0, 0
+	}
+
+	file = in.files[len(in.files)-1].name
+	located := false
+	for name, n := env, 0; name != "" && n <= len(c); n++ {
+		for i := len(in.files) - 1; i >= 0; i-- {
+			f := in.files[i]
+			for _, k := range []string{key, key + appendSuffix} {
+				l, cl, depth := lookup(f.data, name, k)
+				switch {
+				case depth == 2:
+					return f.name, l, cl
+				case depth == 1 && name == env && !located:
+					file, line, col, located = f.name, l, cl, true
+				}
+			}
+		}
+
+		name = c.parent(name)
+	}
+
+	return file, line, col
+}
+
+// digest returns a checksum of the loaded recipes or an empty string if
+// none were loaded.
+func (in *includer) digest() string {
+	if len(in.loaded) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(in.loaded))
+	for name := range in.loaded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s %x\n", name, in.loaded[name])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// path returns the file of the given include.
+func (in *includer) path(inc string) (string, error) {
+	if strings.HasPrefix(inc, recipePrefix) {
+		recipe := strings.TrimPrefix(inc, recipePrefix)
+		if in.recipes == "" {
+			return "", fmt.Errorf("No recipes available for %s", inc)
+		}
+
+		if recipe == "" ||
+			recipe == "." ||
+			recipe == ".." ||
+			strings.ContainsAny(recipe, `/\`) {
+			return "", fmt.Errorf("Invalid recipe %s", inc)
+		}
+
+		return filepath.Join(in.recipes, recipe+".yml"), nil
+	}
+
+	rel := path.Clean(inc)
+	if path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("Include %s is not inside the repo", inc)
+	}
+
+	return filepath.Join(in.repo, filepath.FromSlash(rel)), nil
+}
@@ -0,0 +1,161 @@
+package project
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	actionDeploy   = "deploy"
+	actionRollback = "rollback"
+	actionPrune    = "prune"
+)
+
+// entry is a single line in an env's journal.
+type entry struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Host     string    `json:"host,omitempty"`
+	Release  string    `json:"release"`
+	Previous string    `json:"previous,omitempty"`
+	Commit   string    `json:"commit,omitempty"`
+}
+
+// journal returns the file that records which release was made from which
+// commit, when current was switched and which releases were pruned.
+//
+// The journal is the bookkeeping of the releases on the hosts of an env and
+// the only source Rollback and Plan read them from. Entries are appended
+// the moment the remote changes, so it stays accurate when a deploy is
+// interrupted, unlike the history which is saved when a deploy starts and
+// ends. The history is an audit log for people and is never read back.
+func (p *Project) journal(env string) string {
+	return filepath.Join(
+		p.workdir,
+		filepath.FromSlash(p.repo.Name()),
+		"journal",
+		env,
+	)
+}
+
+func (p *Project) record(env string, e entry) error {
+	p.work.mu.Lock()
+	defer p.work.mu.Unlock()
+
+	fn := p.journal(env)
+	if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(f).Encode(e); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// releaseCommit returns the commit the given release was deployed from.
+func (p *Project) releaseCommit(env, release string) (string, error) {
+	var commit string
+	err := p.entries(env, func(e entry) {
+		if e.Action == actionDeploy && e.Release == release {
+			commit = e.Commit
+		}
+	})
+
+	if err == nil && commit == "" {
+		err = fmt.Errorf("Unknown release %s for env %s", release, env)
+	}
+
+	return commit, err
+}
+
+// lastRelease returns the release gonzalo last switched current to.
+func (p *Project) lastRelease(env string) (string, error) {
+	var release string
+	err := p.entries(env, func(e entry) {
+		if e.Action != actionPrune {
+			release = e.Release
+		}
+	})
+	if err == nil && release == "" {
+		err = fmt.Errorf("No known releases for env %s", env)
+	}
+
+	return release, err
+}
+
+// releaseBefore returns the most recent live release older than id.
+func (p *Project) releaseBefore(env, id string) (string, error) {
+	live, err := p.liveReleases(env)
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(live) - 1; i >= 0; i-- {
+		if live[i] < id {
+			return live[i], nil
+		}
+	}
+
+	return "", fmt.Errorf("No release before %s for env %s", id, env)
+}
+
+// liveReleases returns the releases that were deployed and not yet pruned,
+// oldest first.
+func (p *Project) liveReleases(env string) ([]string, error) {
+	live := make(map[string]bool)
+	err := p.entries(env, func(e entry) {
+		switch e.Action {
+		case actionDeploy:
+			live[e.Release] = true
+		case actionPrune:
+			delete(live, e.Release)
+		}
+	})
+
+	list := make([]string, 0, len(live))
+	for id := range live {
+		list = append(list, id)
+	}
+	sort.Strings(list)
+
+	return list, err
+}
+
+func (p *Project) entries(env string, cb func(entry)) error {
+	p.work.mu.Lock()
+	defer p.work.mu.Unlock()
+
+	f, err := os.Open(p.journal(env))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e entry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return err
+		}
+
+		cb(e)
+	}
+
+	return s.Err()
+}
@@ -0,0 +1,586 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	yamlLineRE = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	varNameRE  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// builtinVars can not be overridden by Env.Vars.
+var builtinVars = []string{
+	"COMMIT",
+	"SHORT_COMMIT",
+	"ENV",
+	"DEST",
+	"RELEASE_DIR",
+	"CURRENT_DIR",
+	"DEPLOY_USER",
+}
+
+// ConfigError is a single problem in a .deploy file.
+type ConfigError struct {
+	File   string
+	Line   int
+	Column int
+	Env    string
+	// The offending key of the env, if any.
+	Key string
+	Err error
+	// Warnings do not prevent the env from being deployed.
+	Warning bool
+}
+
+func (e *ConfigError) Error() string {
+	msg := e.Err.Error()
+	if e.Env != "" {
+		msg = fmt.Sprintf("env %s: %s", e.Env, msg)
+	}
+
+	if e.Warning {
+		msg = "warning: " + msg
+	}
+
+	switch {
+	case e.File == "":
+		return msg
+	case e.Line == 0:
+		return fmt.Sprintf("%s: %s", e.File, msg)
+	}
+
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, msg)
+}
+
+// ConfigErrors are all problems found in a .deploy file.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Fatal reports whether any of the errors is not a warning.
+func (e ConfigErrors) Fatal() bool {
+	for _, err := range e {
+		if !err.Warning {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e ConfigErrors) errors() ConfigErrors {
+	var errs ConfigErrors
+	for _, err := range e {
+		if !err.Warning {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+func (e ConfigErrors) sort() {
+	sort.SliceStable(e, func(i, j int) bool {
+		if e[i].Line != e[j].Line {
+			return e[i].Line < e[j].Line
+		}
+		return e[i].Column < e[j].Column
+	})
+}
+
+// Lint decodes the .deploy file f with its includes and validates all of
+// its envs. Paths and repo includes are relative to the directory f is
+// in, recipes are read from the recipes directory.
+// The returned error is a ConfigErrors if the files could be read, which
+// might only contain warnings.
+func Lint(f, recipes string) error {
+	in := &includer{repo: filepath.Dir(f), recipes: recipes}
+	c, err := in.load(f, f, nil)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(c))
+	for name := range c {
+		if name != baseEnv {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var errs ConfigErrors
+	for _, name := range names {
+		env, err := c.env(name)
+		if err != nil {
+			errs = append(errs, &ConfigError{Env: name, Err: err})
+			continue
+		}
+
+		envErrs := append(validate(env), checkPaths(filepath.Dir(f), env)...)
+		for _, e := range envErrs {
+			e.Env = name
+		}
+		errs = append(errs, envErrs...)
+	}
+
+	in.locate(c, errs)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	errs.sort()
+	return errs
+}
+
+// decode decodes a config file and rejects unknown keys and values of the
+// wrong type.
+func decode(name string, data []byte) (*configFile, error) {
+	f := &configFile{}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, yamlErrors(name, data, err)
+	}
+
+	for env := range f.Envs {
+		f.Envs[env] = onKeys(f.Envs[env]).(map[interface{}]interface{})
+	}
+
+	var errs ConfigErrors
+	typed := struct {
+		Include []string       `yaml:"include"`
+		Envs    map[string]Env `yaml:",inline"`
+	}{}
+	if err := yaml.Unmarshal(data, &typed); err != nil {
+		errs = yamlErrors(name, data, err)
+	}
+
+	fields := yamlFields(reflect.TypeOf(Env{}))
+	for env, raw := range f.Envs {
+		for _, e := range checkKeys(fields, raw) {
+			line, col := locate(data, append([]string{env}, e.path...)...)
+			errs = append(errs, &ConfigError{
+				File:   name,
+				Line:   line,
+				Column: col,
+				Env:    env,
+				Err:    e.err,
+			})
+		}
+	}
+
+	if len(errs) == 0 {
+		return f, nil
+	}
+
+	errs.sort()
+	return nil, errs
+}
+
+func yamlErrors(name string, data []byte, err error) ConfigErrors {
+	msgs := []string{err.Error()}
+	if terr, ok := err.(*yaml.TypeError); ok {
+		msgs = terr.Errors
+	}
+
+	errs := make(ConfigErrors, len(msgs))
+	for i, msg := range msgs {
+		errs[i] = &ConfigError{File: name, Err: errors.New(msg)}
+		m := yamlLineRE.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+
+		errs[i].Err = errors.New(m[2])
+		errs[i].Line, _ = strconv.Atoi(m[1])
+		errs[i].Column = indent(data, errs[i].Line) + 1
+	}
+
+	return errs
+}
+
+type keyError struct {
+	path []string
+	err  error
+}
+
+// checkKeys returns the keys of raw that are not a field in fields and
+// the keys with an append suffix that are not lists.
+func checkKeys(
+	fields map[string]reflect.Type,
+	raw map[interface{}]interface{},
+) []keyError {
+	var errs []keyError
+	for k, v := range raw {
+		key := fmt.Sprint(k)
+		if key == extendsKey && fields[extendsKey] == nil {
+			continue
+		}
+
+		name := strings.TrimSuffix(key, appendSuffix)
+		t, ok := fields[name]
+		if !ok {
+			errs = append(errs, keyError{
+				[]string{key},
+				fmt.Errorf("Unknown key %s", key),
+			})
+			continue
+		}
+
+		if _, ok := raw[name]; ok && name != key {
+			errs = append(errs, keyError{
+				[]string{key},
+				fmt.Errorf("%s and %s can not both be set", name, key),
+			})
+			continue
+		}
+
+		if name != key && t.Kind() != reflect.Slice {
+			errs = append(errs, keyError{
+				[]string{key},
+				fmt.Errorf("%s is not a list and can not be appended to", name),
+			})
+			continue
+		}
+
+		for _, e := range checkValue(t, v) {
+			e.path = append([]string{key}, e.path...)
+			errs = append(errs, e)
+		}
+	}
+
+	return errs
+}
+
+// checkValue checks the keys of the mappings inside v, which is decoded
+// into a value of type t.
+func checkValue(t reflect.Type, v interface{}) []keyError {
+	switch t.Kind() {
+	case reflect.Struct:
+		if m, ok := v.(map[interface{}]interface{}); ok {
+			return checkKeys(yamlFields(t), m)
+		}
+	case reflect.Slice:
+		var errs []keyError
+		list, _ := v.([]interface{})
+		for _, item := range list {
+			errs = append(errs, checkValue(t.Elem(), item)...)
+		}
+		return errs
+	case reflect.Map:
+		var errs []keyError
+		m, _ := v.(map[interface{}]interface{})
+		for k, item := range m {
+			for _, e := range checkValue(t.Elem(), item) {
+				e.path = append([]string{fmt.Sprint(k)}, e.path...)
+				errs = append(errs, e)
+			}
+		}
+		return errs
+	}
+
+	return nil
+}
+
+// onKeys replaces the true keys in the mappings inside v with on, which
+// is how yaml 1.1 reads an on key.
+func onKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k, item := range v {
+			if k == true {
+				k = "on"
+			}
+			m[k] = onKeys(item)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = onKeys(v[i])
+		}
+		return list
+	}
+
+	return v
+}
+
+// yamlFields returns the yaml keys of the struct type t.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+// validate checks the values of a resolved env.
+func validate(env Env) ConfigErrors {
+	var errs ConfigErrors
+	add := func(key string, err error) {
+		errs = append(errs, &ConfigError{Key: key, Err: err})
+	}
+
+	if env.Server != "" {
+		errs = append(errs, &ConfigError{
+			Key: "server",
+			Err: fmt.Errorf(
+				"server is deprecated, use host: %s, user: %s and port: %d",
+				env.Host,
+				env.User,
+				port(env),
+			),
+			Warning: true,
+		})
+	}
+
+	if env.Host == "" && len(env.Hosts) == 0 && env.Group == "" {
+		add("host", errors.New("No host specified"))
+	}
+
+	switch {
+	case env.Dest == "":
+		add("dest", errors.New("No dest specified"))
+	case !path.IsAbs(env.Dest):
+		add("dest", fmt.Errorf("Dest %s is not an absolute path", env.Dest))
+	}
+
+	if _, err := root("", env); err != nil {
+		add("root", err)
+	}
+
+	for _, r := range env.Required {
+		if _, err := requiredPath(env, r); err != nil {
+			add("required", err)
+		}
+	}
+
+	if env.BuildKey == "." ||
+		env.BuildKey == ".." ||
+		strings.ContainsAny(env.BuildKey, `/\`) {
+		add("buildkey", fmt.Errorf("Invalid buildkey '%s'", env.BuildKey))
+	}
+
+	if _, err := batches(nil, env); err != nil {
+		add("strategy", err)
+	}
+
+	if env.Port < 0 || env.Port > 65535 {
+		add("port", fmt.Errorf("Invalid port %d", env.Port))
+	}
+
+	if env.BatchSize < 0 {
+		add("batch-size", errors.New("batch-size can not be negative"))
+	}
+
+	if env.MaxFailures < 0 {
+		add("max-failures", errors.New("max-failures can not be negative"))
+	}
+
+	for _, k := range sortedKeys(env.Vars) {
+		if !varNameRE.MatchString(k) {
+			add("vars", fmt.Errorf("Invalid variable name %s", k))
+		}
+
+		for _, b := range builtinVars {
+			if k == b {
+				add("vars", fmt.Errorf("Variable %s can not be overridden", k))
+			}
+		}
+	}
+
+	for _, cmd := range env.Build {
+		if cmd.On == OnRemote {
+			add("build", errors.New("Build commands can not run on the remote"))
+		}
+	}
+
+	for _, name := range backupNames(env) {
+		if env.Backup[name].On == OnLocal {
+			add("backup", errors.New("Backup commands can not run locally"))
+		}
+	}
+
+	for _, cmds := range [][]Command{
+		env.Build,
+		backupCommands(env),
+		env.PreUpload,
+		env.DuringUpload,
+		env.PostUploadCurrent,
+		env.PostUploadNext,
+		env.PostDeploy,
+		env.Health.Commands,
+	} {
+		for _, cmd := range cmds {
+			for _, err := range validateCommand(cmd) {
+				add("", err)
+			}
+		}
+	}
+
+	if _, err := regexp.Compile(env.Health.Body); err != nil {
+		add("health", err)
+	}
+
+	return errs
+}
+
+func validateCommand(cmd Command) []error {
+	var errs []error
+	if cmd.Run == "" {
+		errs = append(errs, errors.New("Command without run"))
+	}
+
+	switch cmd.On {
+	case "", OnLocal, OnRemote:
+	default:
+		errs = append(errs, fmt.Errorf("%s: invalid on %s", cmd.Run, cmd.On))
+	}
+
+	if cmd.Timeout < 0 || cmd.Retries < 0 {
+		errs = append(
+			errs,
+			fmt.Errorf("%s: timeout and retries can not be negative", cmd.Run),
+		)
+	}
+
+	for k := range cmd.Env {
+		if !varNameRE.MatchString(k) {
+			errs = append(
+				errs,
+				fmt.Errorf("%s: invalid variable name %s", cmd.Run, k),
+			)
+		}
+	}
+
+	return errs
+}
+
+// checkPaths checks whether the Root and Required paths of env exist in
+// the repo checked out at dir.
+func checkPaths(dir string, env Env) ConfigErrors {
+	var errs ConfigErrors
+	src, err := root(dir, env)
+	if err != nil {
+		return nil
+	}
+
+	if fi, err := os.Stat(src); err != nil || !fi.IsDir() {
+		errs = append(errs, &ConfigError{
+			Key: "root",
+			Err: fmt.Errorf("Root %s is not a directory", env.Root),
+		})
+	}
+
+	for _, r := range env.Required {
+		p := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(r, "/")))
+		if _, err := os.Lstat(p); err != nil {
+			errs = append(errs, &ConfigError{
+				Key: "required",
+				Err: fmt.Errorf("Required path %s does not exist", r),
+			})
+		}
+	}
+
+	return errs
+}
+
+// locate returns the line and column of the nested key path in the block
+// style yaml data. Falls back to the deepest parent that was found.
+func locate(data []byte, keys ...string) (line, col int) {
+	line, col, _ = lookup(data, keys...)
+	return
+}
+
+// lookup is locate that also returns the amount of keys that were found.
+func lookup(data []byte, keys ...string) (line, col, depth int) {
+	lines := strings.Split(string(data), "\n")
+	parent, start := -1, 0
+	for _, key := range keys {
+		if key == "" {
+			return
+		}
+
+		child, found := -1, false
+		for i := start; i < len(lines); i++ {
+			trimmed := strings.TrimLeft(lines[i], " ")
+			if trimmed == "" || trimmed[0] == '#' {
+				continue
+			}
+
+			in := len(lines[i]) - len(trimmed)
+			if in <= parent {
+				break
+			}
+
+			if child == -1 {
+				child = in
+			}
+
+			if in != child || !isKey(trimmed, key) {
+				continue
+			}
+
+			line, col, found = i+1, in+1, true
+			parent, start = in, i+1
+			break
+		}
+
+		if !found {
+			return
+		}
+		depth++
+	}
+
+	return
+}
+
+func isKey(s, key string) bool {
+	for _, k := range []string{key, strconv.Quote(key), "'" + key + "'"} {
+		if strings.HasPrefix(s, k) &&
+			strings.HasPrefix(strings.TrimLeft(s[len(k):], " "), ":") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// indent returns the indentation of the given line.
+func indent(data []byte, line int) int {
+	lines := strings.Split(string(data), "\n")
+	if line < 1 || line > len(lines) {
+		return 0
+	}
+
+	l := lines[line-1]
+	return len(l) - len(strings.TrimLeft(l, " "))
+}
@@ -0,0 +1,213 @@
+package project
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLocate(t *testing.T) {
+	data := []byte(`# comment
+all:
+  user: www
+
+prod:
+  "host": h
+  vars:
+    user: x
+  build:
+    - run: a
+      timeout: 3
+  'user':   deploy
+`)
+
+	tests := []struct {
+		keys []string
+		line int
+		col  int
+	}{
+		{[]string{"all"}, 2, 1},
+		{[]string{"all", "user"}, 3, 3},
+		{[]string{"prod", "host"}, 6, 3},
+		{[]string{"prod", "vars", "user"}, 8, 5},
+		{[]string{"prod", "user"}, 12, 3},
+		// Keys inside lists are not found, the parent is.
+		{[]string{"prod", "build", "timeout"}, 9, 3},
+		{[]string{"prod", "nope"}, 5, 1},
+		{[]string{"nope"}, 0, 0},
+		// Errors without a key are located at their env.
+		{[]string{"prod", ""}, 5, 1},
+	}
+
+	for _, test := range tests {
+		line, col := locate(data, test.keys...)
+		if line != test.line || col != test.col {
+			t.Errorf(
+				"%v: expected %d:%d, got %d:%d",
+				test.keys,
+				test.line,
+				test.col,
+				line,
+				col,
+			)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		errs []string
+	}{
+		{
+			name: "valid",
+			data: `
+prod:
+  build+:
+    - a
+    - run: b
+      on: local
+      env: {A: a}
+  backup:
+    db:
+      run: dump
+      on: remote
+`,
+		},
+		{
+			name: "unknown keys",
+			data: `
+prod:
+  post-deplyo: [a]
+  health:
+    urll: x
+`,
+			errs: []string{
+				"test:3:3: env prod: Unknown key post-deplyo",
+				"test:5:5: env prod: Unknown key urll",
+			},
+		},
+		{
+			name: "unknown key of a command",
+			data: `
+prod:
+  build:
+    - run: a
+      tmeout: 3
+`,
+			errs: []string{"test:3:3: env prod: Unknown key tmeout"},
+		},
+		{
+			name: "append to a non list",
+			data: `
+prod:
+  host+: [a]
+`,
+			errs: []string{
+				"test:3:3: env prod: host is not a list and can not be appended to",
+			},
+		},
+		{
+			name: "append and replace",
+			data: `
+prod:
+  build: [a]
+  build+: [b]
+`,
+			errs: []string{"test:4:3: env prod: build and build+ can not both be set"},
+		},
+		{
+			name: "wrong type",
+			data: `
+prod:
+  batch-size: many
+`,
+			errs: []string{
+				"test:3:3: cannot unmarshal !!str `many` into int",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := decode("test", []byte(test.data))
+		var got []string
+		if err != nil {
+			errs, ok := err.(ConfigErrors)
+			if !ok {
+				t.Errorf("%s: expected ConfigErrors, got %v", test.name, err)
+				continue
+			}
+
+			for _, e := range errs {
+				got = append(got, e.Error())
+			}
+		}
+
+		if !reflect.DeepEqual(got, test.errs) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.errs, got)
+		}
+	}
+}
+
+func TestOnKeys(t *testing.T) {
+	in := map[interface{}]interface{}{
+		true: "local",
+		"build": []interface{}{
+			map[interface{}]interface{}{"run": "a", true: "remote"},
+		},
+	}
+
+	want := map[interface{}]interface{}{
+		"on": "local",
+		"build": []interface{}{
+			map[interface{}]interface{}{"run": "a", "on": "remote"},
+		},
+	}
+
+	if got := onKeys(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	in := &includer{files: []source{
+		{"recipe:base", []byte("all:\n  dest: /srv\nprod:\n  build: [a]\n")},
+		{".deploy", []byte("include: [recipe:base]\nprod:\n  host: h\n  build+: [b]\n")},
+	}}
+	c := Config{
+		"all":  {"dest": "/srv"},
+		"prod": {"host": "h", "build+": []interface{}{"a", "b"}},
+	}
+
+	tests := []struct {
+		env  string
+		key  string
+		file string
+		line int
+		col  int
+	}{
+		{"prod", "host", ".deploy", 3, 3},
+		{"prod", "build", ".deploy", 4, 3},
+		{"prod", "dest", "recipe:base", 2, 3},
+		{"prod", "root", ".deploy", 2, 1},
+		{"prod", "", ".deploy", 2, 1},
+		{"nope", "host", ".deploy", 0, 0},
+	}
+
+	for _, test := range tests {
+		file, line, col := in.position(c, test.env, test.key)
+		if file != test.file || line != test.line || col != test.col {
+			t.Errorf(
+				"%s %s: expected %s:%d:%d, got %s:%d:%d",
+				test.env,
+				test.key,
+				test.file,
+				test.line,
+				test.col,
+				file,
+				line,
+				col,
+			)
+		}
+	}
+}
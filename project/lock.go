@@ -0,0 +1,147 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/frizinak/gonzalo/lock"
+	"github.com/frizinak/gonzalo/ssh/sshconn"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	lockFile = ".gonzalo.lock"
+
+	// exit status of the lock command when the lock is already held.
+	lockHeld = 3
+)
+
+func (p *Project) lockKey(env string) string {
+	return p.repo.Name() + ":" + env
+}
+
+// remoteLock creates the lock file in dest on the remote. The returned
+// func removes it. A lock left behind by a gonzalo process on this machine
+// that no longer runs, e.g. after a crash, is removed and taken over.
+func remoteLock(
+	ctx context.Context,
+	conn *sshconn.Connection,
+	dest string,
+	user string,
+) (func(), error) {
+	fn := path.Join(dest, lockFile)
+	hostname, _ := os.Hostname()
+	content := strings.Join(
+		[]string{
+			user,
+			time.Now().Format(time.RFC3339),
+			hostname,
+			strconv.Itoa(os.Getpid()),
+		},
+		"\t",
+	)
+
+	for attempt := 0; ; attempt++ {
+		stdout, stderr, err := conn.Output(
+			ctx,
+			fmt.Sprintf(
+				"mkdir -p %s && { (set -C; printf '%%s\\n' %s > %s) 2>/dev/null || { cat %[3]s; exit %d; }; }",
+				sshconn.Quote(dest),
+				sshconn.Quote(content),
+				sshconn.Quote(fn),
+				lockHeld,
+			),
+			nil,
+		)
+
+		exit, ok := err.(*ssh.ExitError)
+		if !ok || exit.ExitStatus() != lockHeld {
+			if err != nil {
+				return nil, cmdError(err, stderr)
+			}
+
+			break
+		}
+
+		held := strings.TrimSpace(string(stdout))
+		if attempt == 0 && staleLock(held, hostname) {
+			if err := removeLock(ctx, conn, fn, held); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		l := lock.Lock{Owner: "unknown"}
+		parts := strings.Split(held, "\t")
+		if len(parts) >= 2 {
+			l.Owner = parts[0]
+			l.Since, _ = time.Parse(time.RFC3339, parts[1])
+		}
+
+		return nil, &lock.InUseError{
+			Key:  conn.Addr().String() + ":" + fn,
+			Lock: l,
+		}
+	}
+
+	return func() {
+		conn.Output(
+			context.Background(),
+			fmt.Sprintf("rm -f %s", sshconn.Quote(fn)),
+			nil,
+		)
+	}, nil
+}
+
+// staleLock reports whether the content of a lock file was written by a
+// process on the given host that is gone.
+func staleLock(content, hostname string) bool {
+	parts := strings.Split(content, "\t")
+	if len(parts) != 4 || hostname == "" || parts[2] != hostname {
+		return false
+	}
+
+	pid, err := strconv.Atoi(parts[3])
+	if err != nil || pid <= 0 {
+		return false
+	}
+
+	return !running(pid)
+}
+
+// running reports whether a process with the given pid exists.
+func running(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = proc.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, os.ErrPermission)
+}
+
+// removeLock removes the lock file fn if its content is still content.
+func removeLock(
+	ctx context.Context,
+	conn *sshconn.Connection,
+	fn, content string,
+) error {
+	_, stderr, err := conn.Output(
+		ctx,
+		fmt.Sprintf(
+			"test \"$(cat %s)\" != %s || rm -f %[1]s",
+			sshconn.Quote(fn),
+			sshconn.Quote(content),
+		),
+		nil,
+	)
+
+	return cmdError(err, stderr)
+}
@@ -0,0 +1,36 @@
+package project
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+func TestStaleLock(t *testing.T) {
+	c := exec.Command("true")
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	self := strconv.Itoa(os.Getpid())
+	gone := strconv.Itoa(c.Process.Pid)
+	tests := []struct {
+		content string
+		stale   bool
+	}{
+		{"dev\t2020-01-01T00:00:00Z\thost\t" + gone, true},
+		{"dev\t2020-01-01T00:00:00Z\thost\t" + self, false},
+		{"dev\t2020-01-01T00:00:00Z\tother\t" + gone, false},
+		{"dev\t2020-01-01T00:00:00Z\thost\tx", false},
+		// Locks written by older versions have no host or pid.
+		{"dev\t2020-01-01T00:00:00Z", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if stale := staleLock(test.content, "host"); stale != test.stale {
+			t.Errorf("%q: expected stale %t, got %t", test.content, test.stale, stale)
+		}
+	}
+}
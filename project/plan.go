@@ -0,0 +1,218 @@
+package project
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// PlannedPhase describes what a phase would run.
+type PlannedPhase struct {
+	Phase Phase
+	// Whether the commands run on the gonzalo machine.
+	Local bool
+	Dir   string
+	// The commands as they are passed to the shell, which expands Vars.
+	Commands []Command
+	// Why the phase would be skipped, if it would.
+	Skip string
+}
+
+// Plan describes what a deploy would do, without touching the remote.
+type Plan struct {
+	Commitish string
+	Commit    string
+	Env       string
+	// Hosts in the batches they would be deployed to.
+	Hosts    [][]string
+	Port     int
+	User     string
+	Dest     string
+	Root     string
+	BuildKey string
+
+	Release  string
+	Previous string
+
+	// Backup files and the commands producing them.
+	Backup map[string]Command
+	// Env.Required paths, relative to Root on the remote.
+	Required []string
+
+	// Variables exported to every command.
+	Vars   map[string]string
+	Phases []*PlannedPhase
+
+	// Releases that would be pruned, according to the local journal.
+	Prune []string
+}
+
+// Plan resolves the env at the given commitish and returns what Deploy
+// would do. Remote state is taken from the local journal so the plan
+// might be inaccurate if the remote was changed by hand.
+func (p *Project) Plan(
+	ctx context.Context,
+	user, commitish, env string,
+) (*Plan, error) {
+	conf, commit, err := p.configEnv(ctx, commitish, env)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.checkRequired(ctx, commit, conf); err != nil {
+		return nil, err
+	}
+
+	hosts, err := p.hosts(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	batches, err := batches(hosts, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Commitish: commitish,
+		Commit:    commit,
+		Env:       env,
+		Hosts:     batches,
+		Port:      port(conf),
+		User:      conf.User,
+		Dest:      conf.Dest,
+		Root:      conf.Root,
+		BuildKey:  conf.BuildKey,
+		Release:   newID(time.Now()),
+	}
+
+	for _, r := range conf.Required {
+		rel, err := requiredPath(conf, r)
+		if err != nil {
+			return nil, err
+		}
+		plan.Required = append(plan.Required, rel)
+	}
+
+	live, err := p.liveReleases(env)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.entries(env, func(e entry) {
+		if e.Action != actionPrune {
+			plan.Previous = e.Release
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	live = append(live, plan.Release)
+	if keep := conf.keep(); keep >= 0 {
+		plan.Prune = prunable(live, nil, plan.Release, keep)
+	}
+
+	d := &deploy{
+		project: p,
+		env:     conf,
+		result: &Result{
+			User:    user,
+			Commit:  commit,
+			Env:     env,
+			Release: plan.Release,
+		},
+		host:     &HostResult{Previous: plan.Previous},
+		releases: newReleases(nil, conf.Dest),
+		masker:   newMasker(conf),
+	}
+
+	plan.Vars = d.vars()
+	for k, v := range plan.Vars {
+		plan.Vars[k] = d.mask(v)
+	}
+
+	plan.Backup = make(map[string]Command, len(conf.Backup))
+	for name, cmd := range conf.Backup {
+		plan.Backup[name] = d.maskCommand(cmd)
+	}
+	dir, err := root(p.workspace(plan.Release), conf)
+	if err != nil {
+		return nil, err
+	}
+
+	build := &PlannedPhase{Phase: PhaseBuild, Local: true, Dir: dir}
+	build.Commands = d.expandAll(conf.Build)
+	cache, err := p.buildCache(commit, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if cache != "" {
+		if _, err := os.Stat(cache); err == nil {
+			build.Skip = "reusing build " + cache
+		}
+	}
+
+	phase := func(ph Phase, cmds []Command, dir func(*deploy) string) *PlannedPhase {
+		return &PlannedPhase{
+			Phase:    ph,
+			Dir:      dir(d),
+			Commands: d.expandAll(cmds),
+		}
+	}
+
+	backups := phase(PhaseBackup, backupCommands(conf), current)
+	postCurrent := phase(PhasePostUploadCurrent, conf.PostUploadCurrent, current)
+	if plan.Previous == "" {
+		backups.Skip = "no current release"
+		postCurrent.Skip = "no current release"
+	}
+
+	plan.Phases = []*PlannedPhase{
+		build,
+		backups,
+		phase(PhasePreUpload, conf.PreUpload, current),
+		phase(PhaseDuringUpload, conf.DuringUpload, current),
+		postCurrent,
+		phase(PhasePostUploadNext, conf.PostUploadNext, next),
+		phase(PhasePostDeploy, conf.PostDeploy, liveDir),
+		phase(PhaseHealth, healthCommands(conf), liveDir),
+	}
+
+	return plan, nil
+}
+
+// expandAll prepares cmds for the shell and masks the secrets in them.
+func (d *deploy) expandAll(cmds []Command) []Command {
+	list := make([]Command, len(cmds))
+	for i, cmd := range cmds {
+		list[i] = d.maskCommand(cmd)
+		list[i].Run = expand(list[i].Run)
+	}
+
+	return list
+}
+
+func liveDir(d *deploy) string {
+	return d.releases.CurrentDir()
+}
+
+func healthCommands(env Env) []Command {
+	cmds := append([]Command{}, env.Health.Commands...)
+	if env.Health.URL != "" {
+		cmds = append(cmds, Command{Run: "GET " + env.Health.URL})
+	}
+
+	return cmds
+}
+
+func backupCommands(env Env) []Command {
+	names := backupNames(env)
+	cmds := make([]Command, len(names))
+	for i, name := range names {
+		cmds[i] = env.Backup[name]
+	}
+
+	return cmds
+}
@@ -1,43 +1,198 @@
 package project
 
 import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
 	"path/filepath"
+	"strconv"
 
+	"github.com/frizinak/gonzalo/events"
 	"github.com/frizinak/gonzalo/git"
+	"github.com/frizinak/gonzalo/history"
+	"github.com/frizinak/gonzalo/lock"
+	"github.com/frizinak/gonzalo/secrets"
+	"github.com/frizinak/gonzalo/ssh/sshconn"
 	"github.com/frizinak/gonzalo/ssh/sshmanager"
+	"github.com/frizinak/gonzalo/stores"
+	"github.com/frizinak/gonzalo/users"
+	"golang.org/x/crypto/ssh"
 )
 
+const defaultPort = 22
+
 type Project struct {
-	repo *git.Repo
-	fn   string
-	ssh  *sshmanager.Pool
+	repo    *git.Repo
+	fn      string
+	ssh     *sshmanager.Pool
+	sshkey  ssh.Signer
+	workdir string
+	backups stores.BackupStorage
+	locks   *lock.Manager
+	history history.Store
+	streams *events.Hub
+	groups  map[string][]string
+	secrets *secrets.Key
+	recipes string
+	users   users.Store
+	roles   map[string]Role
+	work    *Workdir
 }
 
 func New(
 	repo *git.Repo,
 	config string,
 	ssh *sshmanager.Pool,
+	sshkey ssh.Signer,
+	workdir string,
+	work *Workdir,
+	backups stores.BackupStorage,
+	locks *lock.Manager,
+	history history.Store,
+	streams *events.Hub,
+	groups map[string][]string,
+	secrets *secrets.Key,
+	recipes string,
+	users users.Store,
+	roles map[string]Role,
 ) *Project {
-	return &Project{repo, config, ssh}
+	return &Project{
+		repo:    repo,
+		fn:      config,
+		ssh:     ssh,
+		sshkey:  sshkey,
+		workdir: workdir,
+		backups: backups,
+		locks:   locks,
+		history: history,
+		streams: streams,
+		groups:  groups,
+		secrets: secrets,
+		recipes: recipes,
+		users:   users,
+		roles:   roles,
+		work:    work,
+	}
 }
 
-func (p *Project) Config(commitish string) (*Config, error) {
-	if err := p.repo.Update(); err != nil {
+func (p *Project) Config(ctx context.Context, commitish string) (
+	*Config,
+	error,
+) {
+	c, _, _, err := p.config(ctx, commitish)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := p.repo.Reset(commitish); err != nil {
-		return nil, err
+	return &c, nil
+}
+
+// config resolves the commitish and loads the config of the commit it
+// resolved to. The commit is returned with the config and the includer
+// that loaded it, both are read under a single repo lock so a concurrent
+// fetch can not move the commitish in between.
+func (p *Project) config(ctx context.Context, commitish string) (
+	Config,
+	*includer,
+	string,
+	error,
+) {
+	if err := p.repo.Lock(ctx); err != nil {
+		return nil, nil, "", err
+	}
+	defer p.repo.Unlock()
+
+	if err := p.repo.Update(ctx); err != nil {
+		return nil, nil, "", err
+	}
+
+	commit, err := p.repo.Resolve(ctx, commitish)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if err := p.repo.Reset(ctx, commit); err != nil {
+		return nil, nil, "", err
+	}
+
+	in := &includer{repo: p.repo.Path(), recipes: p.recipes}
+	c, err := in.load(p.fn, filepath.Join(p.repo.Path(), p.fn), nil)
+	if err != nil {
+		return nil, nil, "", err
 	}
 
-	return decodeFile(filepath.Join(p.repo.Path(), p.fn))
+	return c, in, commit, nil
+}
+
+// ConfigEnv returns the env of the config at the given commitish with its
+// secrets decrypted.
+func (p *Project) ConfigEnv(ctx context.Context, commitish, env string) (
+	Env,
+	error,
+) {
+	e, _, err := p.configEnv(ctx, commitish, env)
+	return e, err
 }
 
-func (p *Project) ConfigEnv(commitish, env string) (Env, error) {
-	c, err := p.Config(commitish)
+// configEnv is ConfigEnv that also returns the commit the commitish
+// resolved to, which is the commit the env was read from.
+func (p *Project) configEnv(ctx context.Context, commitish, env string) (
+	Env,
+	string,
+	error,
+) {
+	c, in, commit, err := p.config(ctx, commitish)
 	if err != nil {
-		return Env{}, err
+		return Env{}, "", err
+	}
+
+	e, err := c.GetEnv(env)
+	if errs, ok := err.(ConfigErrors); ok {
+		in.locate(c, errs)
+	}
+	if err != nil {
+		return e, commit, err
+	}
+
+	e.recipes = in.digest()
+	return e, commit, p.decrypt(env, &e)
+}
+
+func (p *Project) export(ctx context.Context, commit, dir string) error {
+	if err := p.repo.Lock(ctx); err != nil {
+		return err
+	}
+	defer p.repo.Unlock()
+	return p.repo.Export(ctx, commit, dir)
+}
+
+// Events returns the event stream of the deploy or rollback currently
+// running for the env, or nil if there is none.
+func (p *Project) Events(env string) *events.Stream {
+	return p.streams.Get(p.lockKey(env))
+}
+
+func (p *Project) connection(
+	ctx context.Context,
+	host, user string,
+	port int,
+) (*sshconn.Connection, error) {
+	if host == "" {
+		return nil, errors.New("No host specified")
+	}
+
+	logger := log.New(os.Stdout, "ssh-"+host, log.LstdFlags)
+	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := p.ssh.Add(ctx, logger, p.sshkey, addr, user, true)
+	if err != nil {
+		return nil, err
 	}
 
-	return c.GetEnv(env)
+	return m.Connection(), nil
 }
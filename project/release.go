@@ -0,0 +1,215 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/frizinak/gonzalo/ssh/sshconn"
+)
+
+const (
+	releasesDir = "releases"
+	currentLink = "current"
+	busyPrefix  = ".busy."
+)
+
+var releaseRE = regexp.MustCompile(`^[0-9]{14}-[0-9a-f]{6}$`)
+
+// releases manages the release layout under an env's Dest:
+//
+//	<dest>/releases/<id>        one directory per deploy
+//	<dest>/releases/.busy.<id>  marks a release a deploy is working on
+//	<dest>/current              symlink to the live release
+type releases struct {
+	conn *sshconn.Connection
+	dest string
+}
+
+func newReleases(conn *sshconn.Connection, dest string) *releases {
+	return &releases{conn, dest}
+}
+
+// Dir returns the remote path of the given release.
+func (r *releases) Dir(id string) string {
+	return path.Join(r.dest, releasesDir, id)
+}
+
+// CurrentDir returns the remote path of the current symlink.
+func (r *releases) CurrentDir() string {
+	return path.Join(r.dest, currentLink)
+}
+
+// Current returns the id of the release current points to or an empty
+// string if there is none.
+func (r *releases) Current(ctx context.Context) (string, error) {
+	stdout, stderr, err := r.conn.Output(
+		ctx,
+		fmt.Sprintf("readlink %s || true", sshconn.Quote(r.CurrentDir())),
+		nil,
+	)
+	if err != nil {
+		return "", cmdError(err, stderr)
+	}
+
+	target := strings.TrimSpace(string(stdout))
+	if target == "" {
+		return "", nil
+	}
+
+	return path.Base(target), nil
+}
+
+// Switch atomically points current to the given release.
+func (r *releases) Switch(ctx context.Context, id string) error {
+	tmp := r.CurrentDir() + ".tmp." + id
+	_, stderr, err := r.conn.Output(
+		ctx,
+		fmt.Sprintf(
+			"test -d %s && ln -sfn %s %s && mv -Tf %[3]s %[4]s",
+			sshconn.Quote(r.Dir(id)),
+			sshconn.Quote(path.Join(releasesDir, id)),
+			sshconn.Quote(tmp),
+			sshconn.Quote(r.CurrentDir()),
+		),
+		nil,
+	)
+
+	return cmdError(err, stderr)
+}
+
+// Begin creates the releases directory and marks the given release as busy
+// so it is never pruned while a deploy is using it.
+func (r *releases) Begin(ctx context.Context, id string) error {
+	dir := path.Join(r.dest, releasesDir)
+	_, stderr, err := r.conn.Output(
+		ctx,
+		fmt.Sprintf(
+			"mkdir -p %s && touch %s",
+			sshconn.Quote(dir),
+			sshconn.Quote(path.Join(dir, busyPrefix+id)),
+		),
+		nil,
+	)
+
+	return cmdError(err, stderr)
+}
+
+// End removes the busy marker of the given release.
+func (r *releases) End(ctx context.Context, id string) error {
+	_, stderr, err := r.conn.Output(
+		ctx,
+		fmt.Sprintf(
+			"rm -f %s",
+			sshconn.Quote(path.Join(r.dest, releasesDir, busyPrefix+id)),
+		),
+		nil,
+	)
+
+	return cmdError(err, stderr)
+}
+
+// List returns all release ids, oldest first, and the set of releases that
+// are marked busy.
+func (r *releases) List(ctx context.Context) ([]string, map[string]bool, error) {
+	stdout, stderr, err := r.conn.Output(
+		ctx,
+		fmt.Sprintf(
+			"ls -1a %s",
+			sshconn.Quote(path.Join(r.dest, releasesDir)),
+		),
+		nil,
+	)
+	if err != nil {
+		return nil, nil, cmdError(err, stderr)
+	}
+
+	list := make([]string, 0)
+	busy := make(map[string]bool)
+	for _, name := range strings.Split(string(stdout), "\n") {
+		if strings.HasPrefix(name, busyPrefix) {
+			busy[strings.TrimPrefix(name, busyPrefix)] = true
+			continue
+		}
+
+		if releaseRE.MatchString(name) {
+			list = append(list, name)
+		}
+	}
+
+	sort.Strings(list)
+	return list, busy, nil
+}
+
+// Remove removes the given release unless it is current.
+func (r *releases) Remove(ctx context.Context, id string) error {
+	_, stderr, err := r.conn.Output(
+		ctx,
+		fmt.Sprintf(
+			"test \"$(readlink %s)\" = %s || rm -rf -- %s",
+			sshconn.Quote(r.CurrentDir()),
+			sshconn.Quote(path.Join(releasesDir, id)),
+			sshconn.Quote(r.Dir(id)),
+		),
+		nil,
+	)
+
+	return cmdError(err, stderr)
+}
+
+// Prune removes all but the keep most recent releases older than current.
+// Current, busy releases and releases newer than current are never removed.
+// A negative keep disables pruning.
+func (r *releases) Prune(
+	ctx context.Context,
+	current string,
+	keep int,
+) ([]string, error) {
+	if keep < 0 || current == "" {
+		return nil, nil
+	}
+
+	list, busy, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prunable := prunable(list, busy, current, keep)
+	if len(prunable) == 0 {
+		return nil, nil
+	}
+
+	dirs := make([]string, len(prunable))
+	for i, id := range prunable {
+		dirs[i] = sshconn.Quote(r.Dir(id))
+	}
+
+	_, stderr, err := r.conn.Output(
+		ctx,
+		"rm -rf -- "+strings.Join(dirs, " "),
+		nil,
+	)
+	if err != nil {
+		return nil, cmdError(err, stderr)
+	}
+
+	return prunable, nil
+}
+
+func prunable(list []string, busy map[string]bool, current string, keep int) []string {
+	previous := make([]string, 0, len(list))
+	for _, id := range list {
+		if id < current && !busy[id] {
+			previous = append(previous, id)
+		}
+	}
+
+	if len(previous) <= keep {
+		return nil
+	}
+
+	return previous[:len(previous)-keep]
+}
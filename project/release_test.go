@@ -0,0 +1,72 @@
+package project
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestPrunable(t *testing.T) {
+	list := []string{"1", "2", "3", "4", "5"}
+	tests := []struct {
+		name    string
+		busy    map[string]bool
+		current string
+		keep    int
+		want    []string
+	}{
+		{name: "keep all but one", current: "5", keep: 3, want: []string{"1"}},
+		{name: "keep none", current: "5", keep: 0, want: []string{"1", "2", "3", "4"}},
+		{name: "keep more than there are", current: "5", keep: 10},
+		{name: "keep exactly", current: "5", keep: 4},
+		{
+			name:    "newer than current",
+			current: "3",
+			keep:    1,
+			want:    []string{"1"},
+		},
+		{name: "oldest is current", current: "1", keep: 0},
+		{
+			name:    "busy",
+			busy:    map[string]bool{"1": true, "3": true},
+			current: "5",
+			keep:    1,
+			want:    []string{"2"},
+		},
+		{
+			name:    "busy current",
+			busy:    map[string]bool{"5": true},
+			current: "5",
+			keep:    2,
+			want:    []string{"1", "2"},
+		},
+	}
+
+	for _, test := range tests {
+		got := prunable(list, test.busy, test.current, test.keep)
+		if len(got) == 0 && len(test.want) == 0 {
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestPruneNothing(t *testing.T) {
+	// Neither case touches the remote.
+	r := &releases{}
+	for _, test := range []struct {
+		current string
+		keep    int
+	}{
+		{"5", -1},
+		{"", 1},
+	} {
+		pruned, err := r.Prune(context.Background(), test.current, test.keep)
+		if err != nil || pruned != nil {
+			t.Errorf("%+v: expected nothing pruned, got %v, %v", test, pruned, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// checkRequired makes sure every Env.Required path exists at the given
+// commit and lies inside Env.Root.
+func (p *Project) checkRequired(
+	ctx context.Context,
+	commit string,
+	env Env,
+) error {
+	if err := p.repo.Lock(ctx); err != nil {
+		return err
+	}
+	defer p.repo.Unlock()
+
+	for _, r := range env.Required {
+		if _, err := requiredPath(env, r); err != nil {
+			return err
+		}
+
+		ok, err := p.repo.Exists(ctx, commit, r)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return fmt.Errorf(
+				"Required path %s does not exist at commit %s",
+				r,
+				commit,
+			)
+		}
+	}
+
+	return nil
+}
+
+// requiredPath returns the Env.Required path r relative to Env.Root.
+func requiredPath(env Env, r string) (string, error) {
+	rel := path.Clean(strings.TrimPrefix(r, "/"))
+	root := path.Clean(strings.TrimPrefix(env.Root, "/"))
+	if root == "." {
+		return rel, nil
+	}
+
+	if rel == root {
+		return ".", nil
+	}
+
+	if !strings.HasPrefix(rel, root+"/") {
+		return "", fmt.Errorf(
+			"Required path %s is not inside root %s",
+			r,
+			env.Root,
+		)
+	}
+
+	return strings.TrimPrefix(rel, root+"/"), nil
+}
+
+// required uploads the Env.Required paths into the new release.
+func required(ctx context.Context, d *deploy, pr *PhaseResult) error {
+	if len(d.env.Required) == 0 {
+		return nil
+	}
+
+	src, err := root(d.build, d.env)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, len(d.env.Required))
+	for i, r := range d.env.Required {
+		if paths[i], err = requiredPath(d.env, r); err != nil {
+			return err
+		}
+	}
+
+	n, err := d.conn.UploadPaths(
+		ctx,
+		src,
+		paths,
+		d.releases.Dir(d.result.Release),
+	)
+	d.host.Uploaded += n
+	return err
+}
@@ -0,0 +1,117 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/frizinak/gonzalo/events"
+)
+
+// Rollback points current back to the given release, or to the release
+// deployed before the last one if releaseID is empty, on every host and
+// runs PostDeploy again using the configuration of the commit that release
+// was deployed from.
+func (p *Project) Rollback(
+	ctx context.Context,
+	user, env, releaseID string,
+) (*Result, error) {
+	res := &Result{User: user, Env: env, Rollback: true}
+	return p.execute(res, func(res *Result, s *events.Stream) error {
+		return p.rollback(ctx, res, s, releaseID)
+	})
+}
+
+func (p *Project) rollback(
+	ctx context.Context,
+	res *Result,
+	s *events.Stream,
+	releaseID string,
+) error {
+	if releaseID == "" {
+		last, err := p.lastRelease(res.Env)
+		if err != nil {
+			return err
+		}
+
+		if releaseID, err = p.releaseBefore(res.Env, last); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	res.Release = releaseID
+	if res.Commit, err = p.releaseCommit(res.Env, releaseID); err != nil {
+		return err
+	}
+
+	res.Commitish = res.Commit
+	env, err := p.ConfigEnv(ctx, res.Commit, res.Env)
+	if err != nil {
+		return err
+	}
+
+	if err := p.authorize(res.User, res.Env, env); err != nil {
+		return err
+	}
+
+	if env.Dest == "" {
+		return errors.New("No dest specified")
+	}
+
+	hosts, err := p.hosts(env)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := p.locks.Acquire(ctx, p.lockKey(res.Env), res.User, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	defer p.streams.Register(p.lockKey(res.Env), s)()
+
+	d := &deploy{
+		project:   p,
+		env:       env,
+		result:    res,
+		workspace: p.workspace(res.ID),
+		events:    s,
+		masker:    newMasker(env),
+	}
+	defer d.cleanWorkspace()
+
+	// Local commands of post-deploy run in the build, which is not kept.
+	if hasLocal(env.PostDeploy) {
+		if err := d.exportWorkspace(ctx); err != nil {
+			return err
+		}
+		d.build = d.workspace
+	}
+
+	return d.onHosts(ctx, hosts, rollbackHost)
+}
+
+// rollbackHost points current to the release of the rollback on a single
+// host.
+func rollbackHost(ctx context.Context, d *deploy) error {
+	if d.result.Release == d.host.Previous {
+		return fmt.Errorf("Release %s is already current", d.result.Release)
+	}
+
+	return d.run(ctx, []stage{
+		{{PhaseSwitch, switchRelease}},
+		{{PhasePostDeploy, remote(postDeploy, current)}},
+	})
+}
+
+// hasLocal reports whether any of cmds runs locally.
+func hasLocal(cmds []Command) bool {
+	for _, cmd := range cmds {
+		if cmd.On == OnLocal {
+			return true
+		}
+	}
+
+	return false
+}
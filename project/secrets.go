@@ -0,0 +1,131 @@
+package project
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/frizinak/gonzalo/secrets"
+)
+
+const masked = "********"
+
+// Encrypt encrypts value for use in the .deploy file of the project.
+func (p *Project) Encrypt(value string) (string, error) {
+	if p.secrets == nil {
+		return "", errors.New("No secret key to encrypt values with")
+	}
+
+	return p.secrets.Encrypt(p.repo.Name(), value)
+}
+
+// decrypt replaces the encrypted values in env with their plaintext and
+// remembers them so they can be masked. Only whole values are decrypted,
+// use Env.Vars to put a secret in a command.
+func (p *Project) decrypt(name string, env *Env) error {
+	var plain []string
+	err := walkStrings(reflect.ValueOf(env).Elem(), func(s string) (string, error) {
+		if !secrets.IsEncrypted(s) {
+			return s, nil
+		}
+
+		if p.secrets == nil {
+			return "", &ConfigError{
+				Env: name,
+				Err: errors.New("No secret key to decrypt values with"),
+			}
+		}
+
+		v, err := p.secrets.Decrypt(p.repo.Name(), s)
+		if err != nil {
+			return "", &ConfigError{Env: name, Err: err}
+		}
+
+		plain = append(plain, v)
+		return v, nil
+	})
+
+	env.secrets = plain
+	return err
+}
+
+// walkStrings replaces every string inside v with the result of fn.
+func walkStrings(v reflect.Value, fn func(string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := fn(v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Field(i).CanSet() {
+				continue
+			}
+
+			if err := walkStrings(v.Field(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := walkStrings(v.Index(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			item := reflect.New(v.Type().Elem()).Elem()
+			item.Set(v.MapIndex(k))
+			if err := walkStrings(item, fn); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, item)
+		}
+	}
+
+	return nil
+}
+
+// newMasker returns a replacer that hides the secrets of env.
+func newMasker(env Env) *strings.Replacer {
+	pairs := make([]string, 0, 2*len(env.secrets))
+	for _, s := range env.secrets {
+		if s != "" {
+			pairs = append(pairs, s, masked)
+		}
+	}
+
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	return strings.NewReplacer(pairs...)
+}
+
+// mask hides the secrets of the env in s.
+func (d *deploy) mask(s string) string {
+	if d.masker == nil {
+		return s
+	}
+
+	return d.masker.Replace(s)
+}
+
+func (d *deploy) maskCommand(cmd Command) Command {
+	if d.masker == nil {
+		return cmd
+	}
+
+	masked := cmd
+	masked.Run = d.mask(cmd.Run)
+	if cmd.Env != nil {
+		masked.Env = make(map[string]string, len(cmd.Env))
+		for k, v := range cmd.Env {
+			masked.Env[k] = d.mask(v)
+		}
+	}
+
+	return masked
+}
@@ -0,0 +1,77 @@
+package project
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/frizinak/gonzalo/git"
+	"github.com/frizinak/gonzalo/secrets"
+)
+
+func TestDecrypt(t *testing.T) {
+	key, err := secrets.NewKey(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := git.New("", "gh", "v", "p", git.Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Project{repo: repo, secrets: key}
+	pass, err := p.Encrypt("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := Env{
+		Host:  "h",
+		Vars:  map[string]string{"DB_PASS": pass, "DB_USER": "u"},
+		Build: []Command{{Run: "make", Env: map[string]string{"TOKEN": pass}}},
+	}
+	if err := p.decrypt("prod", &env); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Env{
+		Host:  "h",
+		Vars:  map[string]string{"DB_PASS": "hunter2", "DB_USER": "u"},
+		Build: []Command{{Run: "make", Env: map[string]string{"TOKEN": "hunter2"}}},
+	}
+	env.secrets = nil
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("expected %+v, got %+v", want, env)
+	}
+
+	other := &Project{repo: repo}
+	env = Env{Vars: map[string]string{"DB_PASS": pass}}
+	if _, ok := other.decrypt("prod", &env).(*ConfigError); !ok {
+		t.Error("expected a ConfigError without a key")
+	}
+}
+
+func TestMask(t *testing.T) {
+	d := &deploy{masker: newMasker(Env{secrets: []string{"hunter2", "", "s3"}})}
+	cmd := d.maskCommand(Command{
+		Run: "mysql -phunter2",
+		Env: map[string]string{"A": "s3cret", "B": "b"},
+	})
+
+	want := Command{
+		Run: "mysql -p" + masked,
+		Env: map[string]string{"A": masked + "cret", "B": "b"},
+	}
+	if !reflect.DeepEqual(cmd, want) {
+		t.Errorf("expected %+v, got %+v", want, cmd)
+	}
+
+	if s := d.mask("no secrets"); s != "no secrets" {
+		t.Errorf("expected the string to be unchanged, got %s", s)
+	}
+
+	if newMasker(Env{}) != nil {
+		t.Error("expected no masker without secrets")
+	}
+}
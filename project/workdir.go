@@ -0,0 +1,17 @@
+package project
+
+import "sync"
+
+// Workdir is the state of the working directory shared by all Projects of
+// a server, which are created per request.
+type Workdir struct {
+	// guards the journals and builds.
+	mu sync.Mutex
+
+	// Cached builds in use by running deploys.
+	builds map[string]int
+}
+
+func NewWorkdir() *Workdir {
+	return &Workdir{builds: make(map[string]int)}
+}
@@ -0,0 +1,102 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Prefix marks an encrypted value.
+const Prefix = "secret:v1:"
+
+const keySize = 32
+
+// Key encrypts and decrypts values with AES-256-GCM. The project a value
+// is encrypted for is authenticated, so it can not be decrypted for
+// another project.
+type Key struct {
+	aead cipher.AEAD
+}
+
+func NewKey(key []byte) (*Key, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("Secret key should be %d bytes", keySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{aead}, nil
+}
+
+// LoadKey reads the key in file and generates it if it does not exist.
+func LoadKey(file string) (*Key, error) {
+	key, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		key = make([]byte, keySize)
+		if _, err = rand.Read(key); err != nil {
+			return nil, err
+		}
+
+		err = ioutil.WriteFile(file, key, 0600)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKey(key)
+}
+
+// IsEncrypted reports whether s is a value returned by Encrypt.
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, Prefix)
+}
+
+// Encrypt encrypts value for the given project.
+func (k *Key) Encrypt(project, value string) (string, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	data := k.aead.Seal(nonce, nonce, []byte(value), []byte(project))
+	return Prefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Decrypt decrypts a value encrypted for the given project.
+func (k *Key) Decrypt(project, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return "", errors.New("Value is not encrypted")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(
+		strings.TrimPrefix(value, Prefix),
+	)
+	if err != nil || len(data) < k.aead.NonceSize() {
+		return "", errors.New("Invalid encrypted value")
+	}
+
+	n := k.aead.NonceSize()
+	plain, err := k.aead.Open(nil, data[:n], data[n:], []byte(project))
+	if err != nil {
+		return "", errors.New(
+			"Could not decrypt value, it might be encrypted for another project or key",
+		)
+	}
+
+	return string(plain), nil
+}
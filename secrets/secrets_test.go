@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func key(t *testing.T, b byte) *Key {
+	k, err := NewKey(bytes.Repeat([]byte{b}, keySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+func TestRoundTrip(t *testing.T) {
+	k := key(t, 1)
+	for _, value := range []string{"", "hunter2", "multi\nline ünicode"} {
+		enc, err := k.Encrypt("gh/v/p", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !IsEncrypted(enc) || (value != "" && strings.Contains(enc, value)) {
+			t.Errorf("%q: not encrypted: %s", value, enc)
+		}
+
+		dec, err := k.Decrypt("gh/v/p", enc)
+		if err != nil {
+			t.Errorf("%q: %s", value, err)
+			continue
+		}
+
+		if dec != value {
+			t.Errorf("expected %q, got %q", value, dec)
+		}
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	k := key(t, 1)
+	enc, err := k.Encrypt("gh/v/p", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     *Key
+		project string
+		value   string
+	}{
+		{"other project", k, "gh/v/other", enc},
+		{"other key", key(t, 2), "gh/v/p", enc},
+		{"not encrypted", k, "gh/v/p", "hunter2"},
+		{"not base64", k, "gh/v/p", Prefix + "!!"},
+		{"too short", k, "gh/v/p", Prefix + "AAAA"},
+		{"tampered", k, "gh/v/p", enc[:len(enc)-4] + "AAAA"},
+	}
+
+	for _, test := range tests {
+		if v, err := test.key.Decrypt(test.project, test.value); err == nil {
+			t.Errorf("%s: expected an error, got %q", test.name, v)
+		}
+	}
+}
+
+func TestNewKeySize(t *testing.T) {
+	if _, err := NewKey(make([]byte, keySize-1)); err == nil {
+		t.Error("expected an error for a short key")
+	}
+}
@@ -1,15 +1,25 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"sync"
 
+	"github.com/frizinak/gonzalo/events"
 	"github.com/frizinak/gonzalo/git"
+	"github.com/frizinak/gonzalo/history"
+	"github.com/frizinak/gonzalo/lock"
 	"github.com/frizinak/gonzalo/project"
+	"github.com/frizinak/gonzalo/secrets"
 	"github.com/frizinak/gonzalo/ssh/sshconn"
 	"github.com/frizinak/gonzalo/ssh/sshmanager"
 	"github.com/frizinak/gonzalo/stores"
+	"github.com/frizinak/gonzalo/users"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -22,6 +32,21 @@ type Gonzalo struct {
 
 	ssh *sshmanager.Pool
 	git *git.Pool
+
+	workdir string
+	work    *project.Workdir
+	recipes string
+	backups stores.BackupStorage
+	locks   *lock.Manager
+	history history.Store
+	streams *events.Hub
+	secrets *secrets.Key
+	users   users.Store
+
+	mu     sync.RWMutex
+	groups map[string][]string
+	// Roles required to deploy, by project and env.
+	roles map[string]map[string]project.Role
 }
 
 func New(
@@ -29,7 +54,13 @@ func New(
 	gitAuth map[string]git.Auth,
 	hostKeyStore stores.KeyStorage,
 	privateKeyStore stores.KeyStorage,
+	backupStore stores.BackupStorage,
+	historyStore history.Store,
+	secretKey *secrets.Key,
+	userStore users.Store,
 	gitdir string,
+	workdir string,
+	recipedir string,
 ) (*Gonzalo, error) {
 	gitpool := git.NewPool(gitdir)
 	for provider := range gitAuth {
@@ -37,15 +68,27 @@ func New(
 	}
 
 	gonzalo := &Gonzalo{
-		sshkey,
-		sshmanager.NewPool(hostKeyStore, privateKeyStore, 2048),
-		gitpool,
+		sshkey:  sshkey,
+		ssh:     sshmanager.NewPool(hostKeyStore, privateKeyStore, 2048),
+		git:     gitpool,
+		workdir: workdir,
+		work:    project.NewWorkdir(),
+		recipes: recipedir,
+		backups: backupStore,
+		locks:   lock.NewManager(),
+		history: historyStore,
+		streams: events.NewHub(),
+		secrets: secretKey,
+		users:   userStore,
+		groups:  make(map[string][]string),
+		roles:   make(map[string]map[string]project.Role),
 	}
 
 	return gonzalo, nil
 }
 
 func (g *Gonzalo) SSHClient(
+	ctx context.Context,
 	host, port, user string,
 ) (*sshconn.Connection, error) {
 	logger := log.New(os.Stdout, "ssh-"+host, log.LstdFlags)
@@ -54,7 +97,7 @@ func (g *Gonzalo) SSHClient(
 		return nil, err
 	}
 
-	m, err := g.ssh.Add(logger, g.sshkey, addr, user, true)
+	m, err := g.ssh.Add(ctx, logger, g.sshkey, addr, user, true)
 	if err != nil {
 		return nil, err
 	}
@@ -62,6 +105,151 @@ func (g *Gonzalo) SSHClient(
 	return m.Connection(), nil
 }
 
+// SetHostGroup defines a named list of hosts envs can deploy to using
+// their Group field.
+func (g *Gonzalo) SetHostGroup(name string, hosts []string) {
+	g.mu.Lock()
+	g.groups[name] = append([]string{}, hosts...)
+	g.mu.Unlock()
+}
+
+// SetRole sets the minimum role required to deploy and roll back the env
+// of the project, named <provider>/<vendor>/<project>. Unlike the role in
+// a .deploy file it can not be lowered by whoever deploys.
+func (g *Gonzalo) SetRole(proj, env string, role project.Role) {
+	g.mu.Lock()
+	if g.roles[proj] == nil {
+		g.roles[proj] = make(map[string]project.Role)
+	}
+	g.roles[proj][env] = role
+	g.mu.Unlock()
+}
+
+// LoadRoles sets the roles in the json file, which has the form
+// {"<provider>/<vendor>/<project>": {"<env>": "<role>"}}, see SetRole.
+// A file that does not exist sets no roles.
+func (g *Gonzalo) LoadRoles(file string) error {
+	raw, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var roles map[string]map[string]string
+	if err := json.Unmarshal(raw, &roles); err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+
+	for proj, envs := range roles {
+		for env, name := range envs {
+			role, err := project.ParseRole(name)
+			if err != nil {
+				return fmt.Errorf("%s: %s %s: %s", file, proj, env, err)
+			}
+
+			g.SetRole(proj, env, role)
+		}
+	}
+
+	return nil
+}
+
+// Users returns the users that may deploy.
+func (g *Gonzalo) Users() users.Store {
+	return g.users
+}
+
+// AddUser saves u on behalf of caller, who has to be an admin.
+// While there are no users the first admin is created by adding yourself
+// as an admin, e.g. gonzalo-server adduser $(whoami) admin.
+func (g *Gonzalo) AddUser(caller string, u *users.User) error {
+	list, err := g.users.List()
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 {
+		if u.Name != caller || u.Role != project.RoleAdmin.String() {
+			return fmt.Errorf(
+				"There are no users yet, add yourself as the first admin: adduser %s admin",
+				caller,
+			)
+		}
+
+		return g.users.Save(u)
+	}
+
+	if err := g.admin(caller); err != nil {
+		return err
+	}
+
+	if u.Name == caller && u.Role != project.RoleAdmin.String() {
+		if err := g.otherAdmin(list, caller); err != nil {
+			return err
+		}
+	}
+
+	return g.users.Save(u)
+}
+
+// DeleteUser removes the named user on behalf of caller, who has to be an
+// admin. The last admin can not be removed.
+func (g *Gonzalo) DeleteUser(caller, name string) error {
+	if err := g.admin(caller); err != nil {
+		return err
+	}
+
+	list, err := g.users.List()
+	if err != nil {
+		return err
+	}
+
+	if err := g.otherAdmin(list, name); err != nil {
+		return err
+	}
+
+	return g.users.Delete(name)
+}
+
+// admin returns an error if the named user is not an admin.
+func (g *Gonzalo) admin(name string) error {
+	u, err := g.users.Get(name)
+	if err == users.ErrNotFound {
+		return fmt.Errorf("User %s may not manage users: unknown user", name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if role, _ := project.ParseRole(u.Role); role < project.RoleAdmin {
+		return fmt.Errorf("User %s may not manage users: not an admin", name)
+	}
+
+	return nil
+}
+
+// otherAdmin returns an error if no user in list but the named one is an
+// admin, so the named user can not stop being one.
+func (g *Gonzalo) otherAdmin(list []*users.User, name string) error {
+	for _, u := range list {
+		if role, _ := project.ParseRole(u.Role); u.Name != name &&
+			role >= project.RoleAdmin {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is the last admin", name)
+}
+
+// Recipes returns the directory of the recipes .deploy files can include.
+func (g *Gonzalo) Recipes() string {
+	return g.recipes
+}
+
 func (g *Gonzalo) Repo(provider, vendor, proj string) (*git.Repo, error) {
 	return g.git.Add(provider, vendor, proj)
 }
@@ -75,5 +263,33 @@ func (g *Gonzalo) Project(provider, vendor, proj string) (
 		return nil, err
 	}
 
-	return project.New(repo, DeployFile, g.ssh), nil
+	g.mu.RLock()
+	groups := make(map[string][]string, len(g.groups))
+	for name, hosts := range g.groups {
+		groups[name] = hosts
+	}
+
+	roles := make(map[string]project.Role, len(g.roles[repo.Name()]))
+	for env, role := range g.roles[repo.Name()] {
+		roles[env] = role
+	}
+	g.mu.RUnlock()
+
+	return project.New(
+		repo,
+		DeployFile,
+		g.ssh,
+		g.sshkey,
+		g.workdir,
+		g.work,
+		g.backups,
+		g.locks,
+		g.history,
+		g.streams,
+		groups,
+		g.secrets,
+		g.recipes,
+		g.users,
+		roles,
+	), nil
 }
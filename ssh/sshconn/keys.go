@@ -1,13 +1,14 @@
 package sshconn
 
 import (
+	"context"
 	"io/ioutil"
 	"net"
 
 	"golang.org/x/crypto/ssh"
 )
 
-func HostInfo(pkey ssh.Signer, host, user string) (
+func HostInfo(ctx context.Context, pkey ssh.Signer, host, user string) (
 	ssh.PublicKey,
 	net.Addr,
 	error,
@@ -29,7 +30,7 @@ func HostInfo(pkey ssh.Signer, host, user string) (
 		},
 	}
 
-	conn, err := ssh.Dial("tcp", host, config)
+	conn, err := dial(ctx, "tcp", host, config)
 	if conn != nil {
 		conn.Close()
 	}
@@ -0,0 +1,8 @@
+package sshconn
+
+import "strings"
+
+// Quote quotes s for safe use as a single argument in a posix shell command.
+func Quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -2,14 +2,19 @@ package sshconn
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"net"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
+// How long a stale connection gets to answer a keepalive.
+const aliveTimeout = 10 * time.Second
+
 type Logger interface {
 	Println(v ...interface{})
 }
@@ -40,19 +45,25 @@ func New(
 	}
 }
 
-func (c *Connection) Connect() error {
+func (c *Connection) Connect(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connect(ctx)
+}
+
+func (c *Connection) connect(ctx context.Context) error {
 	if c.hkey == nil {
 		return errors.New("Hostkey cannot be nil")
 	}
 
-	c.Close()
+	c.close()
 	config := &ssh.ClientConfig{
 		User:            c.user,
 		Auth:            []ssh.AuthMethod{ssh.PublicKeys(c.pkey)},
 		HostKeyCallback: ssh.FixedHostKey(c.hkey),
 	}
 
-	conn, err := ssh.Dial(c.addr.Network(), c.addr.String(), config)
+	conn, err := dial(ctx, c.addr.Network(), c.addr.String(), config)
 	if err != nil {
 		return err
 	}
@@ -61,7 +72,48 @@ func (c *Connection) Connect() error {
 	return nil
 }
 
-func (c *Connection) Close() (err error) {
+// dial is ssh.Dial but aborts when ctx is cancelled.
+func dial(
+	ctx context.Context,
+	network,
+	addr string,
+	config *ssh.ClientConfig,
+) (*ssh.Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	close(stop)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.close()
+}
+
+func (c *Connection) close() (err error) {
 	if c.c != nil {
 		err = c.c.Close()
 		c.c = nil
@@ -69,11 +121,11 @@ func (c *Connection) Close() (err error) {
 	return
 }
 
-func (c *Connection) client() (*ssh.Client, error) {
+func (c *Connection) client(ctx context.Context) (*ssh.Client, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.c == nil {
-		if err := c.Connect(); err != nil {
+		if err := c.connect(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -81,61 +133,134 @@ func (c *Connection) client() (*ssh.Client, error) {
 	return c.c, nil
 }
 
-func (c *Connection) Session() (*ssh.Session, error) {
-	client, err := c.client()
+// Session opens a new session on the connection, (re)connecting if needed.
+// It is safe to have multiple sessions open at the same time.
+func (c *Connection) Session(ctx context.Context) (*ssh.Session, error) {
+	client, err := c.client(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	sess, err := client.NewSession()
+	if err == nil {
+		return sess, nil
+	}
+
+	// The server refusing a session, e.g. because of MaxSessions, does not
+	// affect the sessions that are open on the client.
+	if _, ok := err.(*ssh.OpenChannelError); ok || alive(client) {
+		return nil, err
+	}
+
+	// The connection went stale, reconnect once.
 	c.mu.Lock()
-	var sess *ssh.Session
-	defer func() {
-		if sess != nil {
-			sess.Wait()
-		}
-		c.mu.Unlock()
-	}()
+	if c.c == client {
+		c.close()
+	}
+	c.mu.Unlock()
 
-	sess, err = client.NewSession()
-	if err != nil {
+	if client, err = c.client(ctx); err != nil {
 		return nil, err
 	}
 
-	return sess, err
+	return client.NewSession()
+}
+
+// alive reports whether the server still responds on the client within
+// aliveTimeout.
+func alive(client *ssh.Client) bool {
+	res := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		res <- err
+	}()
+
+	select {
+	case err := <-res:
+		return err == nil
+	case <-time.After(aliveTimeout):
+		return false
+	}
 }
 
-func (c *Connection) Output(cmd string, stdin io.Reader) (
+func (c *Connection) Output(
+	ctx context.Context,
+	cmd string,
+	stdin io.Reader,
+) (
 	stdout,
 	stderr []byte,
 	err error,
 ) {
 	var stdoutB bytes.Buffer
 	var stderrB bytes.Buffer
-	var session *ssh.Session
 
-	if session, err = c.Session(); err != nil {
-		return
+	err = c.Stream(ctx, cmd, stdin, &stdoutB, &stderrB)
+	stdout = stdoutB.Bytes()
+	stderr = stderrB.Bytes()
+
+	return
+}
+
+// Stream runs cmd and copies its output to stdout and stderr as it arrives.
+// The remote command is killed when ctx is cancelled.
+func (c *Connection) Stream(
+	ctx context.Context,
+	cmd string,
+	stdin io.Reader,
+	stdout,
+	stderr io.Writer,
+) error {
+	session, err := c.Session(ctx)
+	if err != nil {
+		return err
 	}
 
 	defer session.Close()
 
-	session.Stdout = &stdoutB
-	session.Stderr = &stderrB
+	session.Stdout = stdout
+	session.Stderr = stderr
 	session.Stdin = stdin
 
+	stop := watch(ctx, session)
 	err = session.Run(cmd)
-	stdout = stdoutB.Bytes()
-	stderr = stderrB.Bytes()
+	return stop(err)
+}
 
-	return
+// watch kills the session's command when ctx is cancelled. The returned
+// func must be called when the command finished, it replaces err with
+// ctx.Err() if the command was killed.
+func watch(ctx context.Context, session *ssh.Session) func(error) error {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Signal(ssh.SIGKILL)
+			session.Close()
+		case <-done:
+		}
+	}()
+
+	return func(err error) error {
+		close(done)
+		if cerr := ctx.Err(); cerr != nil {
+			return cerr
+		}
+
+		return err
+	}
 }
 
 func (c *Connection) SetPrivateKey(pkey ssh.Signer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.pkey = pkey
-	c.Close()
+	c.close()
 }
 
 func (c *Connection) PrivateKey() ssh.Signer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.pkey
 }
 
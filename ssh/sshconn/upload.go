@@ -0,0 +1,158 @@
+package sshconn
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Upload streams the tree at the local path src to the remote directory
+// dest as a tar archive. File modes and symlinks are preserved.
+// It returns the amount of bytes transferred.
+func (c *Connection) Upload(ctx context.Context, src, dest string) (
+	int64,
+	error,
+) {
+	return c.UploadPaths(ctx, src, []string{"."}, dest)
+}
+
+// UploadPaths is like Upload but only transfers the given paths, relative
+// to src. Directories are uploaded recursively.
+func (c *Connection) UploadPaths(
+	ctx context.Context,
+	src string,
+	paths []string,
+	dest string,
+) (int64, error) {
+	session, err := c.Session(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return 0, err
+	}
+
+	var stderr strings.Builder
+	session.Stderr = &stderr
+
+	cmd := fmt.Sprintf(
+		"mkdir -p %[1]s && tar -xpf - -C %[1]s",
+		Quote(dest),
+	)
+
+	if err := session.Start(cmd); err != nil {
+		return 0, err
+	}
+
+	stop := watch(ctx, session)
+	w := &countWriter{w: stdin}
+	werr := writeTar(w, src, paths)
+	stdin.Close()
+
+	err = stop(session.Wait())
+	if ctx.Err() != nil {
+		return w.Count(), err
+	}
+
+	if werr != nil {
+		return w.Count(), werr
+	}
+
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s: %s", err, msg)
+		}
+	}
+
+	return w.Count(), err
+}
+
+func writeTar(w io.Writer, src string, paths []string) error {
+	tw := tar.NewWriter(w)
+	walk := func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		return writeTarEntry(tw, path, filepath.ToSlash(rel), fi)
+	}
+
+	for _, p := range paths {
+		if err := filepath.Walk(filepath.Join(src, p), walk); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func writeTarEntry(tw *tar.Writer, path, name string, fi os.FileInfo) error {
+	var link string
+	mode := fi.Mode()
+	switch {
+	case mode&os.ModeSymlink != 0:
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	case mode.IsDir(), mode.IsRegular():
+	default:
+		return nil
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+
+	hdr.Name = name
+	if mode.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if !mode.IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func (c *countWriter) Count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
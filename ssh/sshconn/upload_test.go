@@ -0,0 +1,120 @@
+package sshconn
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+type tarEntry struct {
+	mode os.FileMode
+	link string
+	data string
+}
+
+func readTar(t *testing.T, r io.Reader) map[string]tarEntry {
+	entries := make(map[string]tarEntry)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hdr.Uid != 0 || hdr.Gid != 0 || hdr.Uname != "" || hdr.Gname != "" {
+			t.Errorf("%s: expected no owner, got %+v", hdr.Name, hdr)
+		}
+
+		entries[hdr.Name] = tarEntry{
+			mode: hdr.FileInfo().Mode(),
+			link: hdr.Linkname,
+			data: string(data),
+		}
+	}
+}
+
+func TestWriteTar(t *testing.T) {
+	src, err := ioutil.TempDir("", "gonzalo-tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	write := func(name, data string, mode os.FileMode) {
+		fn := filepath.Join(src, name)
+		if err := ioutil.WriteFile(fn, []byte(data), mode); err != nil {
+			t.Fatal(err)
+		}
+		// Not affected by the umask.
+		if err := os.Chmod(fn, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Mkdir(filepath.Join(src, "web"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "web"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	write("web/index.php", "<?php", 0644)
+	write("run.sh", "#!/bin/sh", 0755)
+	if err := os.Symlink("web/index.php", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(src, "fifo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		paths []string
+		want  map[string]tarEntry
+	}{
+		{
+			paths: []string{"."},
+			want: map[string]tarEntry{
+				"web/":          {mode: os.ModeDir | 0750},
+				"web/index.php": {mode: 0644, data: "<?php"},
+				"run.sh":        {mode: 0755, data: "#!/bin/sh"},
+				"link":          {mode: os.ModeSymlink | 0777, link: "web/index.php"},
+			},
+		},
+		{
+			paths: []string{"web", "run.sh"},
+			want: map[string]tarEntry{
+				"web/":          {mode: os.ModeDir | 0750},
+				"web/index.php": {mode: 0644, data: "<?php"},
+				"run.sh":        {mode: 0755, data: "#!/bin/sh"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := writeTar(&buf, src, test.paths); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := readTar(t, &buf); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: expected %+v, got %+v", test.paths, test.want, got)
+		}
+	}
+
+	if err := writeTar(ioutil.Discard, src, []string{"nope"}); err == nil {
+		t.Error("expected an error for a missing path")
+	}
+}
@@ -1,6 +1,7 @@
 package sshmanager
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -22,6 +23,7 @@ type Manager struct {
 
 // New returns an ssh connection manager with hostkey verification.
 func New(
+	ctx context.Context,
 	log sshconn.Logger,
 	pkey ssh.Signer,
 	addr net.Addr,
@@ -37,7 +39,7 @@ func New(
 	}
 
 	getFresh := func() (ssh.PublicKey, error) {
-		hkey, _, err := sshconn.HostInfo(pkey, addr.String(), user)
+		hkey, _, err := sshconn.HostInfo(ctx, pkey, addr.String(), user)
 		if err != nil {
 			return nil, err
 		}
@@ -73,7 +75,7 @@ func (m *Manager) Close() error {
 
 // ReplaceKey replaces the current publicKey used in the connection with a
 // newly generated one and resets the connection.
-func (m *Manager) ReplaceKey(bits int) error {
+func (m *Manager) ReplaceKey(ctx context.Context, bits int) error {
 	current, replaced, err := m.pkey()
 	if err != nil || replaced {
 		return err
@@ -99,6 +101,7 @@ func (m *Manager) ReplaceKey(bits int) error {
 
 	rnd := time.Now().UnixNano()
 	_, _, err = m.conn.Output(
+		ctx,
 		fmt.Sprintf(
 			`tmp="$HOME/.ssh/authorized_keys.%d" && \
 			bu="$HOME/.ssh/authorized_keys.gonzalo.backup" && \
@@ -121,6 +124,7 @@ func (m *Manager) ReplaceKey(bits int) error {
 
 	if err := m.setPKey(rawPKey); err != nil {
 		m.conn.Output(
+			context.Background(),
 			`cp "$HOME/.ssh/authorized_keys.gonzalo.backup" \
 			"$HOME/.ssh/authorized_keys"`,
 			nil,
@@ -1,6 +1,7 @@
 package sshmanager
 
 import (
+	"context"
 	"net"
 	"sync"
 
@@ -34,6 +35,7 @@ func (p *Pool) Get(addr net.Addr, user string) *Manager {
 }
 
 func (p *Pool) Add(
+	ctx context.Context,
 	log sshconn.Logger,
 	pkey ssh.Signer,
 	addr net.Addr,
@@ -46,13 +48,18 @@ func (p *Pool) Add(
 
 	p.m.Lock()
 	defer p.m.Unlock()
-	m, err := New(log, pkey, addr, user, p.hstore, p.pstore)
+	// Another caller might have added it while we waited for the lock.
+	if m := p.pool[key(addr, user)]; m != nil {
+		return m, nil
+	}
+
+	m, err := New(ctx, log, pkey, addr, user, p.hstore, p.pstore)
 	if err != nil {
 		return nil, err
 	}
 
 	if replaceKey {
-		if err := m.ReplaceKey(p.bits); err != nil {
+		if err := m.ReplaceKey(ctx, p.bits); err != nil {
 			return nil, err
 		}
 	}
@@ -0,0 +1,142 @@
+package stores
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Artifact describes a stored backup.
+type Artifact struct {
+	Name string
+	Path string
+	Size int64
+	// Hex encoded sha256 of the stored file.
+	Checksum string
+}
+
+type BackupStorage interface {
+	Store(project, env, id, name string, r io.Reader) (*Artifact, error)
+}
+
+type FSBackupStorage struct {
+	dir      string
+	fm       os.FileMode
+	compress bool
+}
+
+// NewFSBackupStorage stores backups as
+// dir/<project>/<env>/<id>/<name>[.gz] with a sha256sum compatible
+// <file>.sha256 next to it.
+func NewFSBackupStorage(
+	dir string,
+	filemode os.FileMode,
+	compress bool,
+) (*FSBackupStorage, error) {
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return &FSBackupStorage{dir, filemode, compress}, nil
+}
+
+func (fs *FSBackupStorage) Store(
+	project, env, id, name string,
+	r io.Reader,
+) (*Artifact, error) {
+	for _, v := range []string{env, id, name} {
+		if !validName(v) {
+			return nil, fmt.Errorf("Invalid backup path component: '%s'", v)
+		}
+	}
+
+	dir := filepath.Join(fs.dir, filepath.FromSlash(project), env, id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	if fs.compress {
+		name += ".gz"
+	}
+
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.fm)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := fs.write(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	a.Name = name
+	a.Path = path
+	sum := fmt.Sprintf("%s  %s\n", a.Checksum, name)
+	if err := ioutil.WriteFile(path+".sha256", []byte(sum), fs.fm); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (fs *FSBackupStorage) write(f io.Writer, r io.Reader) (*Artifact, error) {
+	hash := sha256.New()
+	cw := &countWriter{w: io.MultiWriter(f, hash)}
+
+	var w io.Writer = cw
+	var gz *gzip.Writer
+	if fs.compress {
+		gz = gzip.NewWriter(cw)
+		w = gz
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		return nil, err
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Artifact{
+		Size:     cw.n,
+		Checksum: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+func validName(n string) bool {
+	return n != "" &&
+		n != "." &&
+		n != ".." &&
+		!strings.ContainsAny(n, `/\`)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,129 @@
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// FSStore stores all users in a single json file.
+type FSStore struct {
+	file string
+	m    sync.RWMutex
+}
+
+func NewFSStore(file string) *FSStore {
+	return &FSStore{file: file}
+}
+
+func (fs *FSStore) Get(name string) (*User, error) {
+	fs.m.RLock()
+	defer fs.m.RUnlock()
+	list, err := fs.read()
+	if err != nil {
+		return nil, err
+	}
+
+	u, ok := list[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return u, nil
+}
+
+func (fs *FSStore) Save(u *User) error {
+	if u.Name == "" {
+		return errors.New("User without a name")
+	}
+
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	list, err := fs.read()
+	if err != nil {
+		return err
+	}
+
+	list[u.Name] = u
+	return fs.write(list)
+}
+
+func (fs *FSStore) Delete(name string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	list, err := fs.read()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := list[name]; !ok {
+		return ErrNotFound
+	}
+
+	delete(list, name)
+	return fs.write(list)
+}
+
+func (fs *FSStore) List() ([]*User, error) {
+	fs.m.RLock()
+	defer fs.m.RUnlock()
+	list, err := fs.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return sorted(list), nil
+}
+
+func (fs *FSStore) read() (map[string]*User, error) {
+	list := make(map[string]*User)
+	raw, err := ioutil.ReadFile(fs.file)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*User
+	if err := json.Unmarshal(raw, &users); err != nil {
+		return nil, err
+	}
+
+	for _, u := range users {
+		list[u.Name] = u
+	}
+
+	return list, nil
+}
+
+func (fs *FSStore) write(list map[string]*User) error {
+	raw, err := json.MarshalIndent(sorted(list), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := fs.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fs.file)
+}
+
+func sorted(list map[string]*User) []*User {
+	users := make([]*User, 0, len(list))
+	for _, u := range list {
+		users = append(users, u)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+
+	return users
+}
@@ -0,0 +1,25 @@
+package users
+
+import (
+	"errors"
+)
+
+// ErrNotFound is returned for users that do not exist.
+var ErrNotFound = errors.New("User does not exist")
+
+// User is someone allowed to use gonzalo.
+type User struct {
+	Name string `json:"name"`
+	// Name of the role of the user.
+	Role string `json:"role"`
+}
+
+type Store interface {
+	// Get returns ErrNotFound if the user does not exist.
+	Get(name string) (*User, error)
+	// Save creates or overwrites the user with the same name.
+	Save(*User) error
+	Delete(name string) error
+	// List returns all users sorted by name.
+	List() ([]*User, error)
+}